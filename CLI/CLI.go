@@ -1,6 +1,8 @@
 package CLI
 
 import (
+	"COMP5567-BlockChain/P2P"
+	"COMP5567-BlockChain/features"
 	"flag"
 	"fmt"
 	"gopkg.in/yaml.v3"
@@ -13,19 +15,36 @@ type CLI struct {
 
 func (cli *CLI) PrintUsage() {
 	fmt.Println("Usage:")
-	fmt.Println("	createBlockchain -address ADDRESS - Create a blockchain and send genesis block reward to ADDRESS")
+	fmt.Println("	createBlockchain -address ADDRESS - Create a blockchain and send genesis block reward to ADDRESS, the emission schedule and coinbase maturity of config.yaml are stored with it")
 	fmt.Println("	createWallet - Generates a new key-pair and saves it into the wallet file")
-	fmt.Println("	getBalance -address ADDRESS - Get balance of ADDRESS")
+	fmt.Println("	changePassphrase - Encrypt the wallet file with a new passphrase")
+	fmt.Println("	unlock -timeout SECONDS - Keep the encrypted wallet unlocked for SECONDS, run other commands from another terminal until it returns or is interrupted")
+	fmt.Println("	lock - Lock the encrypted wallet again")
+	fmt.Println("	exportSeed - Print the seed phrase the wallet addresses are derived from")
+	fmt.Println("	importSeed -mnemonic \"WORDS\" -gap N - Restore the wallet from a seed phrase, rediscovering used addresses until N unused ones in a row")
+	fmt.Println("	exportWallet -out PATH [-address ADDRESS] - Export the key of ADDRESS to a PEM file, or the whole wallet to the directory PATH")
+	fmt.Println("	importWallet -in PATH | -address ADDRESS - Import a wallet directory or PEM file, or watch ADDRESS without its key, PUBLIC KEY files are watched too")
+	fmt.Println("	createMultisig -required M -keys KEY,KEY,... - Create an M-of-N multisig address from wallet addresses or hex public keys")
+	fmt.Println("	signPartial -tx FILE -signer ADDRESS [-from MULTISIG -to ADDRESS -amount AMOUNT -fee FEE] - Sign the multisig transaction in FILE, creating it when -from is set, once its outputs and fee are confirmed")
+	fmt.Println("	combineSignatures -tx FILE,FILE,... - Combine the signatures of partial transactions and broadcast the result")
+	fmt.Println("	getBalance [-address ADDRESS] - Get balance of ADDRESS, or of every wallet address including watch-only ones")
 	fmt.Println("	listAddress - Lists all addresses from the wallet file")
-	fmt.Println("	printChain - Print all the blocks of the blockchain")
-	fmt.Println("	send -from ADDRESS -to ADDRESS -record RECORD -mine - Send a Record from address A to address B, if -mine is set, mine on the same node.")
-	fmt.Println("	reindexUTXO - Rebuilds the UTXO set")
+	fmt.Println("	printChain [-from N -to M] - Print the blocks of the blockchain from height N to M, by default from the tip to genesis")
+	fmt.Println("	getBlock -height N | -hash HASH - Print the main chain block at height N or the block with HASH")
+	fmt.Println("	getProof -txid TXID - Print the Merkle proof that the transaction TXID is in its block")
+	fmt.Println("	send -from ADDRESS -to ADDRESS -amount AMOUNT -fee FEE -mine - Send AMOUNT from address A to address B paying FEE to the miner, if -mine is set, mine on the same node.")
+	fmt.Println("	reindexUTXO [-addrindex] - Rebuilds the UTXO set, -addrindex also enables the address index")
+	fmt.Println("	history -address ADDRESS -page N -pageSize M - Print the transactions of ADDRESS newest first, needs the address index")
+	fmt.Println("	supply - Print the circulating supply and the emission schedule")
 	fmt.Println("	startNode -miner ADDRESS - Start a node with ID specified in NODE_ID env. var. -miner enables mining")
 	fmt.Println(" 	switchUser -target Number - Switch the user to target")
 }
 
 type Config struct {
-	nodeID int `yaml:"nodeID"`
+	nodeID           int                       `yaml:"nodeID"`
+	Emission         features.EmissionSchedule `yaml:"emission"`
+	CoinbaseMaturity int                       `yaml:"coinbaseMaturity"`
+	P2P              P2P.PeerConfig            `yaml:"p2p"`
 }
 
 func (cli *CLI) validateArgs() {
@@ -42,9 +61,27 @@ func (cli *CLI) Run() {
 		log.Panic("ERROR When READING YAML file.")
 	}
 
-	var config Config
+	config := Config{Emission: features.Emission, CoinbaseMaturity: features.CoinbaseMaturity, P2P: P2P.PeerSettings}
 	err = yaml.Unmarshal(yamlFile, &config)
+	if err != nil {
+		log.Panic("ERROR When PARSING YAML file.")
+	}
+	err = config.Emission.Validate()
+	if err != nil {
+		log.Panic(err)
+	}
+	if config.CoinbaseMaturity < 0 {
+		log.Panic("ERROR: The coinbase maturity cannot be negative")
+	}
+	err = config.P2P.Validate()
+	if err != nil {
+		log.Panic(err)
+	}
 	nodeID := config.nodeID
+	// only a new chain uses these, an existing one reads the ones it was created with
+	features.Emission = config.Emission
+	features.CoinbaseMaturity = config.CoinbaseMaturity
+	P2P.PeerSettings = config.P2P
 
 	nodeIDString := fmt.Sprintf("%d", nodeID)
 	fmt.Println(yamlFile)
@@ -56,18 +93,58 @@ func (cli *CLI) Run() {
 	getBalanceCmd := flag.NewFlagSet("getBalance", flag.ExitOnError)
 	createBlockchainCmd := flag.NewFlagSet("createBlockchain", flag.ExitOnError)
 	createWalletCmd := flag.NewFlagSet("createWallet", flag.ExitOnError)
+	changePassphraseCmd := flag.NewFlagSet("changePassphrase", flag.ExitOnError)
+	unlockCmd := flag.NewFlagSet("unlock", flag.ExitOnError)
+	lockCmd := flag.NewFlagSet("lock", flag.ExitOnError)
+	exportSeedCmd := flag.NewFlagSet("exportSeed", flag.ExitOnError)
+	importSeedCmd := flag.NewFlagSet("importSeed", flag.ExitOnError)
+	exportWalletCmd := flag.NewFlagSet("exportWallet", flag.ExitOnError)
+	importWalletCmd := flag.NewFlagSet("importWallet", flag.ExitOnError)
+	createMultisigCmd := flag.NewFlagSet("createMultisig", flag.ExitOnError)
+	signPartialCmd := flag.NewFlagSet("signPartial", flag.ExitOnError)
+	combineSignaturesCmd := flag.NewFlagSet("combineSignatures", flag.ExitOnError)
 	listAddressCmd := flag.NewFlagSet("listAddresses", flag.ExitOnError)
 	printChainCmd := flag.NewFlagSet("printChain", flag.ExitOnError)
+	getBlockCmd := flag.NewFlagSet("getBlock", flag.ExitOnError)
+	getProofCmd := flag.NewFlagSet("getProof", flag.ExitOnError)
 	reindexUTXOCmd := flag.NewFlagSet("reindexUTXO", flag.ExitOnError)
+	historyCmd := flag.NewFlagSet("history", flag.ExitOnError)
+	supplyCmd := flag.NewFlagSet("supply", flag.ExitOnError)
 	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
 	startNodeCmd := flag.NewFlagSet("startNode", flag.ExitOnError)
 	switchNodeCmd := flag.NewFlagSet("switchNode", flag.ExitOnError)
 
 	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for")
 	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address to send genesis block reward to")
+	unlockTimeout := unlockCmd.Int("timeout", 300, "Seconds the wallet stays unlocked")
+	importSeedMnemonic := importSeedCmd.String("mnemonic", "", "Seed phrase to restore")
+	importSeedGap := importSeedCmd.Int("gap", features.DefaultGapLimit, "Unused addresses in a row that end the scan, 0 skips it")
+	exportWalletOut := exportWalletCmd.String("out", "", "PEM file or directory to write")
+	exportWalletAddress := exportWalletCmd.String("address", "", "Only export the key of this address")
+	importWalletIn := importWalletCmd.String("in", "", "Wallet directory or PEM file to read")
+	importWalletAddress := importWalletCmd.String("address", "", "Address to watch without its key")
+	createMultisigRequired := createMultisigCmd.Int("required", 0, "Number of signatures required to spend")
+	createMultisigKeys := createMultisigCmd.String("keys", "", "Comma separated wallet addresses or hex public keys")
+	signPartialFile := signPartialCmd.String("tx", "", "Partial transaction file")
+	signPartialSigner := signPartialCmd.String("signer", "", "Wallet address signing the transaction")
+	signPartialFrom := signPartialCmd.String("from", "", "Multisig address to spend from when creating the transaction")
+	signPartialTo := signPartialCmd.String("to", "", "Destination wallet address")
+	signPartialAmount := signPartialCmd.Int("amount", 0, "Amount to send")
+	signPartialFee := signPartialCmd.Int("fee", 0, "Fee paid to the miner")
+	combineSignaturesFiles := combineSignaturesCmd.String("tx", "", "Comma separated partial transaction files")
+	printChainFrom := printChainCmd.Int("from", -1, "Height to start at, the tip by default")
+	printChainTo := printChainCmd.Int("to", -1, "Height to end at, genesis by default")
+	getBlockHeight := getBlockCmd.Int("height", -1, "Height of the main chain block")
+	getBlockHash := getBlockCmd.String("hash", "", "Hash of the block")
+	getProofTXID := getProofCmd.String("txid", "", "ID of the transaction")
+	reindexUTXOAddressIndex := reindexUTXOCmd.Bool("addrindex", false, "Enable the address index")
+	historyAddress := historyCmd.String("address", "", "The address to get the history of")
+	historyPage := historyCmd.Int("page", 1, "Page to print, starting at 1")
+	historyPageSize := historyCmd.Int("pageSize", 20, "Transactions per page")
 	sendFrom := sendCmd.String("from", "", "Source wallet address")
 	sendTo := sendCmd.String("to", "", "Destination wallet address")
 	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
+	sendFee := sendCmd.Int("fee", 0, "Fee paid to the miner")
 	sendMine := sendCmd.Bool("mine", false, "Mine immediately on the same node")
 	startNodeMiner := startNodeCmd.String("miner", "", "Enable mining mode and send reward to ADDRESS")
 	//switchNode := switchNodeCmd.String("target", "", "Switch the user to target")
@@ -88,6 +165,56 @@ func (cli *CLI) Run() {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "changePassphrase":
+		err := changePassphraseCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "unlock":
+		err := unlockCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "lock":
+		err := lockCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "exportSeed":
+		err := exportSeedCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "importSeed":
+		err := importSeedCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "exportWallet":
+		err := exportWalletCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "importWallet":
+		err := importWalletCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createMultisig":
+		err := createMultisigCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "signPartial":
+		err := signPartialCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "combineSignatures":
+		err := combineSignaturesCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "listAddresses":
 		err := listAddressCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -100,12 +227,36 @@ func (cli *CLI) Run() {
 			log.Panic(err)
 		}
 
+	case "getBlock":
+		err := getBlockCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+
+	case "getProof":
+		err := getProofCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+
 	case "reindexUTXO":
 		err := reindexUTXOCmd.Parse(os.Args[2:])
 		if err != nil {
 			log.Panic(err)
 		}
 
+	case "history":
+		err := historyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+
+	case "supply":
+		err := supplyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+
 	case "send":
 		err := sendCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -131,22 +282,106 @@ func (cli *CLI) Run() {
 
 	if getBalanceCmd.Parsed() {
 		if *getBalanceAddress == "" {
-			getBalanceCmd.Usage()
-			os.Exit(1)
+			cli.GetWalletBalance(nodeIDString)
+		} else {
+			cli.GetBalance(*getBalanceAddress, nodeIDString)
 		}
-		cli.GetBalance(*getBalanceAddress, nodeIDString)
 	}
 
 	if createWalletCmd.Parsed() {
 		cli.createWallet(nodeIDString)
 	}
 
+	if changePassphraseCmd.Parsed() {
+		cli.changePassphrase(nodeIDString)
+	}
+
+	if unlockCmd.Parsed() {
+		if *unlockTimeout <= 0 {
+			unlockCmd.Usage()
+			os.Exit(1)
+		}
+		cli.unlock(*unlockTimeout, nodeIDString)
+	}
+
+	if lockCmd.Parsed() {
+		cli.lock(nodeIDString)
+	}
+
+	if exportSeedCmd.Parsed() {
+		cli.exportSeed(nodeIDString)
+	}
+
+	if importSeedCmd.Parsed() {
+		if *importSeedMnemonic == "" || *importSeedGap < 0 {
+			importSeedCmd.Usage()
+			os.Exit(1)
+		}
+		cli.importSeed(*importSeedMnemonic, *importSeedGap, nodeIDString)
+	}
+
+	if exportWalletCmd.Parsed() {
+		if *exportWalletOut == "" {
+			exportWalletCmd.Usage()
+			os.Exit(1)
+		}
+		cli.exportWallet(*exportWalletOut, *exportWalletAddress, nodeIDString)
+	}
+
+	if importWalletCmd.Parsed() {
+		if (*importWalletIn == "") == (*importWalletAddress == "") {
+			importWalletCmd.Usage()
+			os.Exit(1)
+		}
+		cli.importWallet(*importWalletIn, *importWalletAddress, nodeIDString)
+	}
+
+	if createMultisigCmd.Parsed() {
+		if *createMultisigRequired <= 0 || *createMultisigKeys == "" {
+			createMultisigCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createMultisig(*createMultisigRequired, *createMultisigKeys, nodeIDString)
+	}
+
+	if signPartialCmd.Parsed() {
+		if *signPartialFile == "" || *signPartialSigner == "" || (*signPartialFrom != "" && (*signPartialTo == "" || *signPartialAmount <= 0 || *signPartialFee < 0)) {
+			signPartialCmd.Usage()
+			os.Exit(1)
+		}
+		cli.signPartial(*signPartialFile, *signPartialSigner, *signPartialFrom, *signPartialTo, *signPartialAmount, *signPartialFee, nodeIDString)
+	}
+
+	if combineSignaturesCmd.Parsed() {
+		if *combineSignaturesFiles == "" {
+			combineSignaturesCmd.Usage()
+			os.Exit(1)
+		}
+		cli.combineSignatures(*combineSignaturesFiles, nodeIDString)
+	}
+
 	if createBlockchainCmd.Parsed() {
 		cli.createBlockchain(*createBlockchainAddress, nodeIDString)
 	}
 
 	if printChainCmd.Parsed() {
-		cli.PrintChain(nodeIDString)
+		cli.PrintChain(*printChainFrom, *printChainTo, nodeIDString)
+	}
+
+	if getBlockCmd.Parsed() {
+		if (*getBlockHeight < 0) == (*getBlockHash == "") {
+			getBlockCmd.Usage()
+			os.Exit(1)
+		}
+		cli.getBlock(*getBlockHeight, *getBlockHash, nodeIDString)
+	}
+
+	if getProofCmd.Parsed() {
+		if *getProofTXID == "" {
+			getProofCmd.Usage()
+			os.Exit(1)
+		}
+		cli.getProof(*getProofTXID, nodeIDString)
 	}
 
 	if listAddressCmd.Parsed() {
@@ -154,15 +389,27 @@ func (cli *CLI) Run() {
 	}
 
 	if reindexUTXOCmd.Parsed() {
-		cli.ReindexUTXO(nodeIDString)
+		cli.ReindexUTXO(nodeIDString, *reindexUTXOAddressIndex)
+	}
+
+	if historyCmd.Parsed() {
+		if *historyAddress == "" || *historyPage <= 0 || *historyPageSize <= 0 {
+			historyCmd.Usage()
+			os.Exit(1)
+		}
+		cli.history(*historyAddress, *historyPage, *historyPageSize, nodeIDString)
+	}
+
+	if supplyCmd.Parsed() {
+		cli.Supply(nodeIDString)
 	}
 
 	if sendCmd.Parsed() {
-		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 {
+		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 || *sendFee < 0 {
 			sendCmd.Usage()
 			os.Exit(1)
 		}
-		cli.Send(*sendFrom, *sendTo, *sendAmount, nodeIDString, *sendMine)
+		cli.Send(*sendFrom, *sendTo, *sendAmount, *sendFee, nodeIDString, *sendMine)
 	}
 
 	if startNodeCmd.Parsed() {
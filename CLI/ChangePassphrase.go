@@ -0,0 +1,33 @@
+package CLI
+
+import (
+	"COMP5567-BlockChain/features"
+	"fmt"
+	"log"
+)
+
+// changePassphrase encrypts the wallet file with a new passphrase, the first call encrypts a plain wallet
+func (cli *CLI) changePassphrase(nodeID string) {
+	wallets, err := cli.loadWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	passphrase := readPassphrase("New passphrase: ")
+	if readPassphrase("Repeat new passphrase: ") != passphrase {
+		log.Panic("ERROR: Passphrases do not match")
+	}
+
+	err = wallets.SetPassphrase(passphrase)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallets.SaveToFile(nodeID)
+
+	err = features.LockWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Println("Passphrase changed, the wallet is locked")
+}
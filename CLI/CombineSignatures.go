@@ -0,0 +1,56 @@
+package CLI
+
+import (
+	"COMP5567-BlockChain/P2P"
+	"COMP5567-BlockChain/features"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// combineSignatures merges the signatures of the partial transaction files and broadcasts the result
+func (cli *CLI) combineSignatures(files, nodeID string) {
+	var ptx *features.PartialTransaction
+
+	for _, file := range strings.Split(files, ",") {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		partial, err := features.DeserializePartialTransaction(data)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		if ptx == nil {
+			ptx = partial
+		} else if err = ptx.Combine(partial); err != nil {
+			log.Panic(err)
+		}
+	}
+
+	transaction, err := ptx.Finalize()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	blockchain := features.NewBlockChain(nodeID)
+	defer blockchain.GetDB().Close()
+
+	err = blockchain.VerifyTransaction(transaction)
+	if err != nil {
+		log.Panic("ERROR: Combined transaction is invalid: ", err)
+	}
+
+	client := P2P.NewServer("", "", nil, P2P.NewAddressBook("", P2P.PeerSettings.Seeds), P2P.PeerSettings)
+	nodes := client.KnownNodes()
+	if len(nodes) == 0 {
+		client.Close()
+		log.Panic("ERROR: No node is known to send the transaction to, add seeds to config.yaml")
+	}
+	client.SendTX(nodes[0], transaction)
+	client.Close()
+	fmt.Printf("Transaction %x is sent!\n", transaction.ID)
+}
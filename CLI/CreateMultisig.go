@@ -0,0 +1,44 @@
+package CLI
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// createMultisig registers an m-of-n address, keys are addresses of this wallet or hex encoded public keys
+func (cli *CLI) createMultisig(required int, keys, nodeID string) {
+	wallets, err := cli.loadWallets(nodeID)
+	if err != nil && !os.IsNotExist(err) {
+		log.Panic(err)
+	}
+	var publicKeys [][]byte
+
+	for _, key := range strings.Split(keys, ",") {
+		if wallet, ok := wallets.Wallets[key]; ok {
+			publicKeys = append(publicKeys, wallet.PublicKey)
+			continue
+		}
+		if wallet, ok := wallets.WatchOnly[key]; ok && len(wallet.PublicKey) > 0 {
+			publicKeys = append(publicKeys, wallet.PublicKey)
+			continue
+		}
+
+		publicKey, err := hex.DecodeString(key)
+		if err != nil {
+			log.Panicf("ERROR: %s is neither an address of this wallet nor a public key", key)
+		}
+		publicKeys = append(publicKeys, publicKey)
+	}
+
+	address, err := wallets.CreateMultisig(required, publicKeys)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallets.SaveToFile(nodeID)
+
+	fmt.Printf("Your new %d-of-%d multisig address: %s\n", required, len(publicKeys), address)
+	fmt.Printf("Redeem script: %x\n", wallets.GetMultisig(address).RedeemScript)
+}
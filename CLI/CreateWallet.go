@@ -1,14 +1,20 @@
 package CLI
 
 import (
-	"COMP5567-BlockChain/features"
 	"fmt"
+	"log"
+	"os"
 )
 
 func (cli *CLI) createWallet(nodeID string) {
-	wallets, _ := features.NewWallets(nodeID)
+	wallets, err := cli.loadWallets(nodeID)
+	if err != nil && !os.IsNotExist(err) {
+		log.Panic(err)
+	}
 	address := wallets.CreateWallet()
 	wallets.SaveToFile(nodeID)
 
 	fmt.Printf("Your new address: %s\n", address)
+	fmt.Printf("Public key: %x\n", wallets.GetWallet(address).PublicKey)
+	fmt.Printf("Derivation path: %s\n", wallets.GetWallet(address).Path)
 }
@@ -0,0 +1,20 @@
+package CLI
+
+import (
+	"COMP5567-BlockChain/features"
+	"fmt"
+	"log"
+)
+
+func (cli *CLI) exportSeed(nodeID string) {
+	wallets, err := cli.loadWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	if wallets.Mnemonic == "" {
+		log.Panic("ERROR: The wallet has no seed, create an address first")
+	}
+
+	fmt.Printf("Seed phrase: %s\n", wallets.Mnemonic)
+	fmt.Printf("Addresses derived: %d (path %s/INDEX)\n", wallets.NextIndex, features.WalletPath)
+}
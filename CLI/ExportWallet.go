@@ -0,0 +1,37 @@
+package CLI
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// exportWallet writes the key of one address to a PEM file, or the whole wallet to a directory
+func (cli *CLI) exportWallet(out, address, nodeID string) {
+	wallets, err := cli.loadWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if address != "" {
+		data, err := wallets.ExportKey(address)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		err = os.WriteFile(out, data, 0600)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		fmt.Printf("Key of %s is exported to %s\n", address, out)
+		return
+	}
+
+	err = wallets.Export(out)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Wallet is exported to %s, keep it as safe as the wallet file\n", out)
+}
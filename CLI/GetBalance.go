@@ -13,9 +13,51 @@ func (cli *CLI) GetBalance(address, nodeID string) {
 	}
 
 	blockchain := features.NewBlockChain(nodeID)
-	UTXOSet := features.UTXOSet{blockchain}
+	UTXOSet := features.UTXOSet{BlockChain: blockchain}
 	defer blockchain.GetDB().Close()
 
+	balance := addressBalance(&UTXOSet, address)
+
+	fmt.Printf("Balance of '%s': %d\n", address, balance)
+}
+
+// GetWalletBalance prints the balance of every address in the wallet, watch-only ones included
+func (cli *CLI) GetWalletBalance(nodeID string) {
+	wallets, err := cli.loadWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	blockchain := features.NewBlockChain(nodeID)
+	UTXOSet := features.UTXOSet{BlockChain: blockchain}
+	defer blockchain.GetDB().Close()
+
+	spendable := 0
+	for _, address := range wallets.GetAddresses() {
+		balance := addressBalance(&UTXOSet, address)
+		spendable += balance
+		fmt.Printf("Balance of '%s': %d\n", address, balance)
+	}
+
+	shared := 0
+	for _, address := range wallets.GetMultisigAddresses() {
+		balance := addressBalance(&UTXOSet, address)
+		shared += balance
+		fmt.Printf("Balance of '%s' (multisig): %d\n", address, balance)
+	}
+
+	watched := 0
+	for _, address := range wallets.GetWatchOnlyAddresses() {
+		balance := addressBalance(&UTXOSet, address)
+		watched += balance
+		fmt.Printf("Balance of '%s' (watch-only): %d\n", address, balance)
+	}
+
+	fmt.Printf("Total: %d (spendable %d, multisig %d, watch-only %d)\n", spendable+shared+watched, spendable, shared, watched)
+}
+
+// addressBalance sums the unspent outputs locked to the address
+func addressBalance(UTXOSet *features.UTXOSet, address string) int {
 	balance := 0
 	pubKeyHash := utils.Base64Decode([]byte(address))
 	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
@@ -25,5 +67,5 @@ func (cli *CLI) GetBalance(address, nodeID string) {
 		balance += out.Value
 	}
 
-	fmt.Printf("Balance of '%s': %d\n", address, balance)
+	return balance
 }
@@ -0,0 +1,31 @@
+package CLI
+
+import (
+	"COMP5567-BlockChain/features"
+	"encoding/hex"
+	"log"
+)
+
+// getBlock prints the block with the hash, or the main chain block at the height when hash is empty
+func (cli *CLI) getBlock(height int, hash, nodeID string) {
+	blockchain := features.NewBlockChain(nodeID)
+	defer blockchain.GetDB().Close()
+
+	var block features.Block
+	var err error
+	if hash != "" {
+		var blockHash []byte
+		blockHash, err = hex.DecodeString(hash)
+		if err != nil {
+			log.Panic(err)
+		}
+		block, err = blockchain.GetBlock(blockHash)
+	} else {
+		block, err = blockchain.GetBlockByHeight(height)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+
+	printBlock(&block)
+}
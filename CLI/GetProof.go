@@ -0,0 +1,35 @@
+package CLI
+
+import (
+	"COMP5567-BlockChain/features"
+	"encoding/hex"
+	"fmt"
+	"log"
+)
+
+// getProof prints the Merkle inclusion proof of a main chain transaction
+func (cli *CLI) getProof(txid, nodeID string) {
+	id, err := hex.DecodeString(txid)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	blockchain := features.NewBlockChain(nodeID)
+	defer blockchain.GetDB().Close()
+
+	proof, err := blockchain.GetProof(id)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Transaction: %x\n", proof.Transaction.ID)
+	fmt.Printf("Block: %x\n", proof.Header.GetHash())
+	fmt.Printf("Height: %d\n", proof.Header.GetHeight())
+	fmt.Printf("Merkle root: %x\n", proof.Header.MerkleRoot)
+	fmt.Printf("Index: %d\n", proof.Index)
+	for _, hash := range proof.Path {
+		fmt.Printf("\t%x\n", hash)
+	}
+	fmt.Printf("Valid: %t\n", proof.Verify() == nil)
+	fmt.Printf("Proof: %x\n", proof.Serialize())
+}
@@ -0,0 +1,35 @@
+package CLI
+
+import (
+	"COMP5567-BlockChain/features"
+	"COMP5567-BlockChain/utils"
+	"fmt"
+	"log"
+)
+
+// history prints a page of the transactions of the address, newest first
+func (cli *CLI) history(address string, page, pageSize int, nodeID string) {
+	if !features.ValidateAddress(address) {
+		log.Panic("ERROR: Address is not valid")
+	}
+	if page < 1 || pageSize < 1 {
+		log.Panic("ERROR: Pages and page sizes start at 1")
+	}
+
+	blockchain := features.NewBlockChain(nodeID)
+	defer blockchain.GetDB().Close()
+
+	pubKeyHash := utils.Base64Decode([]byte(address))
+	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
+
+	history, total, err := blockchain.AddressHistory(pubKeyHash, (page-1)*pageSize, pageSize)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	pages := (total + pageSize - 1) / pageSize
+	fmt.Printf("History of '%s': %d transactions, page %d of %d\n", address, total, page, pages)
+	for _, entry := range history {
+		fmt.Printf("Height %d  TX %x  received %d  sent %d\n", entry.Height, entry.TXid, entry.Received, entry.Sent)
+	}
+}
@@ -0,0 +1,36 @@
+package CLI
+
+import (
+	"COMP5567-BlockChain/features"
+	"fmt"
+	"log"
+	"os"
+)
+
+// importSeed restores a wallet from its seed phrase, addresses used on the chain are
+// rediscovered until gapLimit unused ones in a row, a gap limit of 0 skips the scan
+func (cli *CLI) importSeed(mnemonic string, gapLimit int, nodeID string) {
+	wallets, err := cli.loadWallets(nodeID)
+	if err != nil && !os.IsNotExist(err) {
+		log.Panic(err)
+	}
+
+	err = wallets.ImportMnemonic(mnemonic)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if gapLimit > 0 {
+		blockchain := features.NewBlockChain(nodeID)
+		UTXOSet := features.UTXOSet{BlockChain: blockchain}
+		found := wallets.Scan(&UTXOSet, gapLimit)
+		blockchain.GetDB().Close()
+
+		fmt.Printf("Found %d used addresses\n", found)
+	}
+	wallets.SaveToFile(nodeID)
+
+	for _, address := range wallets.GetAddresses() {
+		fmt.Printf("%s %s\n", address, wallets.GetWallet(address).Path)
+	}
+}
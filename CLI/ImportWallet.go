@@ -0,0 +1,57 @@
+package CLI
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// importWallet reads a wallet directory or a PEM file, or watches a bare address
+func (cli *CLI) importWallet(in, address, nodeID string) {
+	wallets, err := cli.loadWallets(nodeID)
+	if err != nil && !os.IsNotExist(err) {
+		log.Panic(err)
+	}
+
+	if address != "" {
+		err = wallets.AddWatchOnly(address, nil)
+		if err != nil {
+			log.Panic(err)
+		}
+		wallets.SaveToFile(nodeID)
+
+		fmt.Printf("Watching %s\n", address)
+		return
+	}
+
+	info, err := os.Stat(in)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if info.IsDir() {
+		imported, err := wallets.Import(in)
+		if err != nil {
+			log.Panic(err)
+		}
+		wallets.SaveToFile(nodeID)
+
+		fmt.Printf("Imported %d entries from %s\n", imported, in)
+		return
+	}
+
+	data, err := os.ReadFile(in)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	addresses, err := wallets.ImportKeys(data)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallets.SaveToFile(nodeID)
+
+	for _, address := range addresses {
+		fmt.Printf("Imported %s\n", address)
+	}
+}
@@ -1,19 +1,39 @@
 package CLI
 
 import (
-	"COMP5567-BlockChain/features"
 	"fmt"
 	"log"
 )
 
 func (cli *CLI) listAddresses(nodeID string) {
-	wallets, err := features.NewWallets(nodeID)
+	wallets, err := cli.loadWallets(nodeID)
 	if err != nil {
 		log.Panic(err)
 	}
 	addresses := wallets.GetAddresses()
 
 	for _, address := range addresses {
-		fmt.Println(address)
+		fmt.Println(address, wallets.GetWallet(address).Path)
+	}
+
+	multisigs := wallets.GetMultisigAddresses()
+	if len(multisigs) > 0 {
+		fmt.Println("Multisig addresses:")
+		for _, address := range multisigs {
+			multisig := wallets.GetMultisig(address)
+			fmt.Printf("%s (%d of %d)\n", address, multisig.M, len(multisig.PublicKeys))
+		}
+	}
+
+	watchOnly := wallets.GetWatchOnlyAddresses()
+	if len(watchOnly) > 0 {
+		fmt.Println("Watch-only addresses:")
+		for _, address := range watchOnly {
+			if len(wallets.GetWatchOnly(address).PublicKey) > 0 {
+				fmt.Printf("%s (public key known)\n", address)
+			} else {
+				fmt.Println(address)
+			}
+		}
 	}
 }
@@ -0,0 +1,60 @@
+package CLI
+
+import (
+	"COMP5567-BlockChain/features"
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// stdin is shared by every prompt, a reader per prompt would swallow the lines after it
+var stdin = bufio.NewReader(os.Stdin)
+
+// loadWallets opens the wallet file of the node, asking for the passphrase when it is locked
+func (cli *CLI) loadWallets(nodeID string) (*features.Wallets, error) {
+	wallets, err := features.NewWallets(nodeID)
+	if !errors.Is(err, features.ErrWalletLocked) {
+		return wallets, err
+	}
+
+	return features.OpenWallets(nodeID, readPassphrase("Wallet passphrase: "))
+}
+
+// readPassphrase prompts for a line on the standard input, without echoing it on a terminal
+func readPassphrase(prompt string) string {
+	fmt.Print(prompt)
+
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		passphrase, err := term.ReadPassword(fd)
+		fmt.Println()
+		if err != nil {
+			log.Panic(err)
+		}
+		return string(passphrase)
+	}
+
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		log.Panic(err)
+	}
+
+	return strings.TrimRight(line, "\r\n")
+}
+
+// confirm asks a yes or no question on the standard input, anything but yes is a no
+func confirm(prompt string) bool {
+	fmt.Print(prompt, " [y/N]: ")
+
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return false
+	}
+
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes"
+}
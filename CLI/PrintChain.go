@@ -3,30 +3,52 @@ package CLI
 import (
 	"COMP5567-BlockChain/features"
 	"fmt"
+	"io"
+	"log"
 	"strconv"
 )
 
-func (cli *CLI) PrintChain(nodeID string) {
+// PrintChain prints the main chain blocks from one height to another, both included, a
+// negative from starts at the tip and a negative to ends at genesis
+func (cli *CLI) PrintChain(from, to int, nodeID string) {
 	blockchain := features.NewBlockChain(nodeID)
 	defer blockchain.GetDB().Close()
 
-	bci := blockchain.Iterator()
-
-	for {
-		block := bci.Next()
+	if from < 0 {
+		from = blockchain.GetBestHeight()
+	}
+	if to < 0 {
+		to = 0
+	}
 
-		fmt.Printf("============== Block %x ==============", block.GetHash())
-		fmt.Printf("Height: %d\n", block.GetHeight())
-		fmt.Printf("Prev. block: %x\n", block.GetPreviousHash())
-		pow := features.NewPOW(block)
-		fmt.Printf("POW: %s\n\n", strconv.FormatBool(pow.Validate()))
-		for _, transaction := range block.GetTransactions() {
-			fmt.Println(transaction)
-		}
-		fmt.Printf("\n\n")
+	bci, err := blockchain.RangeIterator(from, to)
+	if err != nil {
+		log.Panic(err)
+	}
 
-		if len(block.GetPreviousHash()) == 0 {
+	for {
+		block, err := bci.Next()
+		if err == io.EOF {
 			break
 		}
+		if err != nil {
+			log.Panic(err)
+		}
+
+		printBlock(block)
+	}
+}
+
+func printBlock(block *features.Block) {
+	fmt.Printf("============== Block %x ==============\n", block.GetHash())
+	fmt.Printf("Height: %d\n", block.GetHeight())
+	fmt.Printf("Prev. block: %x\n", block.GetPreviousHash())
+	fmt.Printf("Merkle root: %x\n", block.MerkleRoot)
+	fmt.Printf("Difficulty: %d\n", block.GetDifficulty())
+	pow := features.NewPOW(&block.BlockHeader)
+	fmt.Printf("POW: %s\n\n", strconv.FormatBool(pow.Validate()))
+	for _, transaction := range block.GetTransactions() {
+		fmt.Println(transaction)
 	}
+	fmt.Printf("\n\n")
 }
@@ -5,11 +5,17 @@ import (
 	"fmt"
 )
 
-func (cli *CLI) ReindexUTXO(nodeID string) {
+func (cli *CLI) ReindexUTXO(nodeID string, addressIndex bool) {
 	blockchain := features.NewBlockChain(nodeID)
-	UTXOSet := features.UTXOSet{blockchain}
+	if addressIndex {
+		blockchain.EnableAddressIndex()
+	}
+	UTXOSet := features.UTXOSet{BlockChain: blockchain}
 	UTXOSet.Reindex()
 
 	count := UTXOSet.CountTransactions()
 	fmt.Printf("Done! There are %d transaction in the UTXO set.\n", count)
+	if blockchain.HasAddressIndex() {
+		fmt.Println("The address index is rebuilt.")
+	}
 }
@@ -7,7 +7,7 @@ import (
 	"log"
 )
 
-func (cli *CLI) Send(from, to string, amount int, nodeID string, mineNow bool) {
+func (cli *CLI) Send(from, to string, amount, fee int, nodeID string, mineNow bool) {
 	if !features.ValidateAddress(from) {
 		log.Panic("ERROR: Sender address is not valid")
 	}
@@ -16,25 +16,37 @@ func (cli *CLI) Send(from, to string, amount int, nodeID string, mineNow bool) {
 	}
 
 	blockchain := features.NewBlockChain(nodeID)
-	UTXOSet := features.UTXOSet{blockchain}
+	UTXOSet := features.UTXOSet{BlockChain: blockchain}
 	defer blockchain.GetDB().Close()
 
-	wallets, err := features.NewWallets(nodeID)
+	wallets, err := cli.loadWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallet, err := wallets.GetSigner(from)
 	if err != nil {
 		log.Panic(err)
 	}
-	wallet := wallets.GetWallet(from)
 
-	transation := features.NewUTXOTransaction(&wallet, to, amount, &UTXOSet)
+	transation := features.NewUTXOTransaction(wallet, to, amount, fee, &UTXOSet)
 
 	if mineNow {
-		cbtx := features.NewCoinbaseTX(from, "")
+		cbtx := features.NewCoinbaseTX(from, "", blockchain.GetBestHeight()+1, fee)
 		txs := []*features.Transaction{cbtx, transation}
 
-		newBlock := blockchain.MineBlock(txs)
-		UTXOSet.Update(newBlock)
+		_, err = blockchain.MineBlock(txs)
+		if err != nil {
+			log.Panic(err)
+		}
 	} else {
-		P2P.SendTX(P2P.GetKnownNodes()[0], transation)
+		client := P2P.NewServer("", "", nil, P2P.NewAddressBook("", P2P.PeerSettings.Seeds), P2P.PeerSettings)
+		nodes := client.KnownNodes()
+		if len(nodes) == 0 {
+			client.Close()
+			log.Panic("ERROR: No node is known to send the transaction to, add seeds to config.yaml")
+		}
+		client.SendTX(nodes[0], transation)
+		client.Close()
 	}
 
 	fmt.Println("Success!")
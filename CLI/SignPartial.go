@@ -0,0 +1,79 @@
+package CLI
+
+import (
+	"COMP5567-BlockChain/features"
+	"fmt"
+	"log"
+	"os"
+)
+
+// signPartial adds the signatures of signer to the partial transaction in file,
+// creating it first when from names the multisig address to spend from
+func (cli *CLI) signPartial(file, signer, from, to string, amount, fee int, nodeID string) {
+	wallets, err := cli.loadWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallet, err := wallets.GetSigner(signer)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	// the outputs spent are checked in the chain, which is closed again before the prompt
+	blockchain := features.NewBlockChain(nodeID)
+
+	var ptx *features.PartialTransaction
+	if from != "" {
+		multisig := wallets.GetMultisig(from)
+		if multisig == nil {
+			log.Panic("ERROR: Multisig address is not in the wallet")
+		}
+		if !features.ValidateAddress(to) {
+			log.Panic("ERROR: Recipient address is not valid")
+		}
+
+		UTXOSet := features.UTXOSet{BlockChain: blockchain}
+		ptx, err = features.NewMultisigTransaction(multisig, to, amount, fee, &UTXOSet)
+	} else {
+		var data []byte
+		data, err = os.ReadFile(file)
+		if err != nil {
+			log.Panic(err)
+		}
+		ptx, err = features.DeserializePartialTransaction(data)
+		if err == nil {
+			err = ptx.CheckPreviousOuts(blockchain)
+		}
+	}
+	blockchain.GetDB().Close()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Transaction %x pays:\n", ptx.Transaction.ID)
+	for _, out := range ptx.Transaction.TXOutputs {
+		if address := out.Address(); address != nil {
+			fmt.Printf("	%d to %s\n", out.Value, address)
+		} else {
+			fmt.Printf("	%d to script %x\n", out.Value, out.Script)
+		}
+	}
+	fmt.Printf("	%d as fee\n", ptx.Fee())
+	if !confirm("Sign it?") {
+		fmt.Println("Transaction is not signed")
+		return
+	}
+
+	err = ptx.Sign(*wallet.PrivateKey, wallet.PublicKey)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = os.WriteFile(file, ptx.Serialize(), 0644)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	required, _, _ := features.ParseMultisigScript(ptx.RedeemScript)
+	fmt.Printf("Transaction %x has %d of %d signatures, saved to %s\n", ptx.Transaction.ID, ptx.SignatureCount(), required, file)
+}
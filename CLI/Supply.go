@@ -0,0 +1,19 @@
+package CLI
+
+import (
+	"COMP5567-BlockChain/features"
+	"fmt"
+)
+
+func (cli *CLI) Supply(nodeID string) {
+	blockchain := features.NewBlockChain(nodeID)
+	UTXOSet := features.UTXOSet{BlockChain: blockchain}
+	defer blockchain.GetDB().Close()
+
+	height := blockchain.GetBestHeight()
+
+	fmt.Printf("Circulating supply: %d\n", UTXOSet.Supply())
+	fmt.Printf("Issued by the schedule up to height %d: %d\n", height, features.Emission.Issued(height+1))
+	fmt.Printf("Next block subsidy: %d\n", features.Emission.Subsidy(height+1))
+	fmt.Printf("Maximum supply: %d\n", features.Emission.MaxSupply)
+}
@@ -0,0 +1,44 @@
+package CLI
+
+import (
+	"COMP5567-BlockChain/features"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func (cli *CLI) unlock(timeout int, nodeID string) {
+	wallets, err := features.OpenWallets(nodeID, readPassphrase("Wallet passphrase: "))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	// the session file holds the wallet key, it is removed as soon as the session ends
+	// rather than when a later command finds it expired
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer cli.lock(nodeID)
+
+	err = wallets.Unlock(nodeID, time.Duration(timeout)*time.Second)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Wallet is unlocked for %d seconds, interrupt to lock it now\n", timeout)
+	select {
+	case <-time.After(time.Duration(timeout) * time.Second):
+	case <-interrupted:
+	}
+}
+
+func (cli *CLI) lock(nodeID string) {
+	err := features.LockWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Println("Wallet is locked")
+}
@@ -0,0 +1,206 @@
+package P2P
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const addressBookFile = "addresses_%s.dat"
+
+// addresses not seen for maxAddressAge seconds are forgotten, seeds excepted
+const maxAddressAge = 7 * 24 * 60 * 60
+
+// maxAddrPerMessage is the most addresses sent in one addr message
+const maxAddrPerMessage = 1000
+
+// maxAddresses is the most addresses a book keeps besides the seeds, a random one makes
+// room for a new one so that a peer cannot flush the book with addresses of its own
+const maxAddresses = 2000
+
+// addrTimePenalty is taken off the times other nodes report, so that a node cannot make
+// its addresses look more recent than the ones this node saw online itself
+const addrTimePenalty = 2 * 60 * 60
+
+// KnownAddress is the address of a node with the unix time it was last seen online, 0 if never
+type KnownAddress struct {
+	Address  string
+	LastSeen int64
+}
+
+// AddressBook keeps every node address once with the last time it was seen, and
+// saves them to a file so a restarted node does not depend on the seed nodes alone
+type AddressBook struct {
+	path  string
+	seeds []string
+
+	mutex     sync.Mutex
+	addresses map[string]int64
+
+	// saving keeps two saves from writing the file at once
+	saving sync.Mutex
+}
+
+// NewAddressBook loads the addresses saved at path, an empty path keeps them in memory only
+func NewAddressBook(path string, seeds []string) *AddressBook {
+	book := &AddressBook{path: path, seeds: seeds, addresses: make(map[string]int64)}
+
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			log.Panic(err)
+		}
+
+		if err == nil {
+			var saved []KnownAddress
+			err = gob.NewDecoder(bytes.NewReader(data)).Decode(&saved)
+			if err != nil {
+				log.Panic(err)
+			}
+			for _, known := range saved {
+				book.add(known.Address, known.LastSeen)
+			}
+		}
+	}
+
+	for _, seed := range seeds {
+		book.add(seed, 0)
+	}
+
+	return book
+}
+
+// Add records an address another node reported, keeping the most recent time it was seen.
+// Times later than the time penalty allows are taken as now minus the penalty. It returns
+// whether the address was new.
+func (book *AddressBook) Add(address string, lastSeen int64) bool {
+	if latest := time.Now().Unix() - addrTimePenalty; lastSeen > latest {
+		lastSeen = latest
+	}
+
+	return book.add(address, lastSeen)
+}
+
+// Seen records that the node at address is online now
+func (book *AddressBook) Seen(address string) {
+	book.add(address, time.Now().Unix())
+}
+
+func (book *AddressBook) add(address string, lastSeen int64) bool {
+	if address == "" {
+		return false
+	}
+
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	previous, ok := book.addresses[address]
+	if ok {
+		if lastSeen > previous {
+			book.addresses[address] = lastSeen
+		}
+		return false
+	}
+
+	if !book.isSeed(address) {
+		book.evict()
+	}
+	book.addresses[address] = lastSeen
+	return true
+}
+
+// evict forgets a random address that is not a seed when the book is full, the caller holds the mutex
+func (book *AddressBook) evict() {
+	var candidates []string
+	for address := range book.addresses {
+		if !book.isSeed(address) {
+			candidates = append(candidates, address)
+		}
+	}
+
+	if len(candidates) >= maxAddresses {
+		delete(book.addresses, candidates[rand.Intn(len(candidates))])
+	}
+}
+
+func (book *AddressBook) Remove(address string) {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	delete(book.addresses, address)
+}
+
+// Addresses returns the known addresses, the most recently seen first
+func (book *AddressBook) Addresses() []KnownAddress {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	var addresses []KnownAddress
+	for address, lastSeen := range book.addresses {
+		addresses = append(addresses, KnownAddress{address, lastSeen})
+	}
+
+	sort.Slice(addresses, func(i, j int) bool {
+		if addresses[i].LastSeen != addresses[j].LastSeen {
+			return addresses[i].LastSeen > addresses[j].LastSeen
+		}
+		return addresses[i].Address < addresses[j].Address
+	})
+
+	return addresses
+}
+
+// Select returns up to count addresses to connect to, preferring the recently seen ones.
+// Addresses not seen for too long are dropped, the seeds are kept as a last resort.
+func (book *AddressBook) Select(count int) []string {
+	horizon := time.Now().Unix() - maxAddressAge
+	var selected []string
+
+	for _, known := range book.Addresses() {
+		if known.LastSeen < horizon && !book.isSeed(known.Address) {
+			book.Remove(known.Address)
+			continue
+		}
+		if len(selected) < count {
+			selected = append(selected, known.Address)
+		}
+	}
+
+	return selected
+}
+
+func (book *AddressBook) isSeed(address string) bool {
+	for _, seed := range book.seeds {
+		if seed == address {
+			return true
+		}
+	}
+	return false
+}
+
+// Save writes the addresses to the file of the book
+func (book *AddressBook) Save() {
+	if book.path == "" {
+		return
+	}
+
+	book.saving.Lock()
+	defer book.saving.Unlock()
+
+	var buff bytes.Buffer
+	err := gob.NewEncoder(&buff).Encode(book.Addresses())
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = ioutil.WriteFile(book.path, buff.Bytes(), 0600)
+	if err != nil {
+		log.Panic(err)
+	}
+}
@@ -0,0 +1,94 @@
+package P2P
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAddressBookDeduplicates(t *testing.T) {
+	book := NewAddressBook("", []string{"localhost:3000"})
+	old := time.Now().Unix() - addrTimePenalty - 1000
+
+	if !book.Add("localhost:3001", old-100) {
+		t.Fatal("a new address was not reported as new")
+	}
+	if book.Add("localhost:3001", old-200) || book.Add("localhost:3000", old-10) {
+		t.Fatal("a known address was reported as new")
+	}
+
+	expected := []KnownAddress{{"localhost:3000", old - 10}, {"localhost:3001", old - 100}}
+	if addresses := book.Addresses(); !reflect.DeepEqual(addresses, expected) {
+		t.Fatalf("got %v instead of %v", addresses, expected)
+	}
+}
+
+func TestAddressBookPenalizesReportedTimes(t *testing.T) {
+	book := NewAddressBook("", nil)
+
+	book.Add("localhost:3001", time.Now().Unix()+1000)
+	book.Add("localhost:3002", time.Now().Unix())
+	book.Seen("localhost:3003")
+
+	addresses := book.Addresses()
+	if addresses[0].Address != "localhost:3003" {
+		t.Fatalf("a reported address came before the one seen online: %v", addresses)
+	}
+	for _, known := range addresses[1:] {
+		if known.LastSeen > time.Now().Unix()-addrTimePenalty {
+			t.Fatalf("a reported address was kept as %v", known)
+		}
+	}
+}
+
+func TestAddressBookIsBounded(t *testing.T) {
+	seeds := []string{"localhost:3000"}
+	book := NewAddressBook("", seeds)
+	old := time.Now().Unix() - addrTimePenalty
+
+	for i := 0; i < 2*maxAddresses; i++ {
+		book.Add(fmt.Sprintf("10.0.%d.%d:3000", i/256, i%256), old)
+	}
+
+	addresses := book.Addresses()
+	if len(addresses) != maxAddresses+len(seeds) {
+		t.Fatalf("the book holds %d addresses", len(addresses))
+	}
+	if !book.isSeed(addresses[len(addresses)-1].Address) {
+		t.Fatal("the seed was evicted")
+	}
+}
+
+func TestAddressBookPrefersRecentlySeen(t *testing.T) {
+	book := NewAddressBook("", []string{"localhost:3000"})
+	now := time.Now().Unix()
+
+	book.Add("localhost:3001", now-maxAddressAge-1)
+	book.Add("localhost:3002", now-60)
+	book.Seen("localhost:3003")
+
+	if selected := book.Select(2); !reflect.DeepEqual(selected, []string{"localhost:3003", "localhost:3002"}) {
+		t.Fatalf("selected %v", selected)
+	}
+	if selected := book.Select(10); !reflect.DeepEqual(selected, []string{"localhost:3003", "localhost:3002", "localhost:3000"}) {
+		t.Fatalf("the stale address was not dropped: %v", selected)
+	}
+}
+
+func TestAddressBookPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "addresses.dat")
+	old := time.Now().Unix() - addrTimePenalty - 1000
+
+	book := NewAddressBook(path, nil)
+	book.Add("localhost:3001", old)
+	book.Add("localhost:3002", old-60)
+	book.Save()
+
+	loaded := NewAddressBook(path, []string{"localhost:3000"})
+	expected := []KnownAddress{{"localhost:3001", old}, {"localhost:3002", old - 60}, {"localhost:3000", 0}}
+	if addresses := loaded.Addresses(); !reflect.DeepEqual(addresses, expected) {
+		t.Fatalf("got %v instead of %v", addresses, expected)
+	}
+}
@@ -0,0 +1,155 @@
+package P2P
+
+import (
+	"COMP5567-BlockChain/features"
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+// maximum number of mempool transactions a mined block includes besides the coinbase
+const maxBlockTransactions = 100
+
+// maximum number of transactions a mempool holds, new ones are dropped once it is full
+const maxMempoolTransactions = 5000
+
+type mempoolEntry struct {
+	tx   *features.Transaction
+	fee  int
+	size int
+}
+
+// SelectTransactions picks the mempool transactions with the highest fee per
+// serialized byte for the next block and returns them with their total fee.
+// Transactions spending unconfirmed, immature or already spent outputs are left for later,
+// and migrated transactions returned by a reorganization can no longer be mined.
+func (server *Server) SelectTransactions() ([]*features.Transaction, int) {
+	blockchain := server.blockchain
+	UTXOSet := features.UTXOSet{BlockChain: blockchain}
+	height := blockchain.GetBestHeight() + 1
+	var entries []mempoolEntry
+
+	for _, tx := range server.mempoolTransactions() {
+		tx := tx
+		if tx.Version != features.TransactionVersion {
+			continue
+		}
+
+		fee, err := UTXOSet.Fee(&tx)
+		if err != nil || fee < 0 || !UTXOSet.IsMature(&tx, height) {
+			continue
+		}
+		entries = append(entries, mempoolEntry{&tx, fee, len(tx.Serialize())})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		left := entries[i].fee * entries[j].size
+		right := entries[j].fee * entries[i].size
+		if left != right {
+			return left > right
+		}
+		return hex.EncodeToString(entries[i].tx.ID) < hex.EncodeToString(entries[j].tx.ID)
+	})
+
+	var txs []*features.Transaction
+	fees := 0
+	spent := make(map[string]bool)
+
+Entries:
+	for _, entry := range entries {
+		if len(txs) == maxBlockTransactions {
+			break
+		}
+
+		for _, in := range entry.tx.TXInputs {
+			if spent[fmt.Sprintf("%x:%d", in.TXid, in.Value)] {
+				continue Entries
+			}
+		}
+
+		if blockchain.VerifyTransaction(entry.tx) != nil {
+			continue
+		}
+
+		for _, in := range entry.tx.TXInputs {
+			spent[fmt.Sprintf("%x:%d", in.TXid, in.Value)] = true
+		}
+		txs = append(txs, entry.tx)
+		fees += entry.fee
+	}
+
+	return txs, fees
+}
+
+// UpdateMempool returns the transactions of disconnected blocks to the mempool
+// and drops the ones that were confirmed by the newly connected blocks
+func (server *Server) UpdateMempool(update *features.ChainUpdate) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	mempool := server.mempool
+	for _, block := range update.Disconnected {
+		for _, tx := range block.GetTransactions() {
+			if !tx.IsCionBase() {
+				mempool[hex.EncodeToString(tx.ID)] = *tx
+			}
+		}
+	}
+
+	for _, block := range update.Connected {
+		for _, tx := range block.GetTransactions() {
+			delete(mempool, hex.EncodeToString(tx.ID))
+		}
+	}
+}
+
+// addToMempool returns whether the transaction was added, it is not when it is
+// already there or the mempool is full
+func (server *Server) addToMempool(tx features.Transaction) bool {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	txID := hex.EncodeToString(tx.ID)
+	if _, ok := server.mempool[txID]; ok || len(server.mempool) >= maxMempoolTransactions {
+		return false
+	}
+
+	server.mempool[txID] = tx
+	return true
+}
+
+func (server *Server) removeFromMempool(txs []*features.Transaction) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	for _, tx := range txs {
+		delete(server.mempool, hex.EncodeToString(tx.ID))
+	}
+}
+
+func (server *Server) mempoolTransaction(txID []byte) (features.Transaction, bool) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	tx, ok := server.mempool[hex.EncodeToString(txID)]
+	return tx, ok
+}
+
+// mempoolTransactions returns a copy of the mempool
+func (server *Server) mempoolTransactions() []features.Transaction {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	var txs []features.Transaction
+	for _, tx := range server.mempool {
+		txs = append(txs, tx)
+	}
+	return txs
+}
+
+func (server *Server) mempoolSize() int {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	return len(server.mempool)
+}
@@ -0,0 +1,72 @@
+package P2P
+
+import (
+	"COMP5567-BlockChain/features"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestInvalidTransactionsStayOutOfTheMempool(t *testing.T) {
+	sim := newSimulation(t, 1)
+	server := sim.nodes[0].server
+	UTXOSet := features.UTXOSet{BlockChain: sim.nodes[0].blockchain}
+
+	valid := features.NewUTXOTransaction(sim.wallets[0], sim.walletAddress(0), 3, 1, &UTXOSet)
+
+	badSignature := features.NewUTXOTransaction(sim.wallets[0], sim.walletAddress(0), 4, 1, &UTXOSet)
+	badSignature.TXInputs[0].Signature[0] ^= 0xff
+
+	missingInput := features.NewUTXOTransaction(sim.wallets[0], sim.walletAddress(0), 5, 1, &UTXOSet)
+	missingInput.TXInputs[0].TXid = make([]byte, 32)
+	missingInput.ID = missingInput.UnsignedHash()
+
+	tests := []struct {
+		name    string
+		tx      *features.Transaction
+		err     error
+		pending bool
+	}{
+		{"valid", valid, nil, true},
+		{"already in the mempool", valid, nil, true},
+		{"bad signature", badSignature, errInvalid, false},
+		{"unknown input", missingInput, nil, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := server.HandleTX(nil, GobEncode(TX{"", test.tx.Serialize()}))
+			if !errors.Is(err, test.err) {
+				t.Fatalf("HandleTX returned %v instead of %v", err, test.err)
+			}
+			if _, ok := server.mempoolTransaction(test.tx.ID); ok != test.pending {
+				t.Fatalf("the transaction is in the mempool: %v", ok)
+			}
+		})
+	}
+
+	if size := server.mempoolSize(); size != 1 {
+		t.Fatalf("the mempool holds %d transactions instead of 1", size)
+	}
+}
+
+func TestMempoolIsBounded(t *testing.T) {
+	sim := newSimulation(t, 1)
+	server := sim.nodes[0].server
+	UTXOSet := features.UTXOSet{BlockChain: sim.nodes[0].blockchain}
+
+	server.mutex.Lock()
+	for i := 0; i < maxMempoolTransactions; i++ {
+		server.mempool[fmt.Sprintf("%064x", i)] = features.Transaction{}
+	}
+	server.mutex.Unlock()
+
+	tx := features.NewUTXOTransaction(sim.wallets[0], sim.walletAddress(0), 3, 1, &UTXOSet)
+	err := server.HandleTX(nil, GobEncode(TX{"", tx.Serialize()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.mempoolTransaction(tx.ID); ok {
+		t.Fatal("a transaction entered the full mempool")
+	}
+}
@@ -0,0 +1,94 @@
+package P2P
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Every message is sent as a frame
+//
+//	magic     4 bytes   networkMagic
+//	command  16 bytes   ASCII name, padded with zero bytes
+//	length    4 bytes   big-endian length of the payload
+//	checksum  4 bytes   first bytes of the SHA-256 of the payload
+//	payload   length bytes
+const networkMagic uint32 = 0x55675567
+const commandLength = 16
+const headerLength = 4 + commandLength + 4 + 4
+
+// frames announcing a larger payload are rejected before it is read
+const maxPayloadLength = 32 << 20
+
+var (
+	ErrBadMagic        = errors.New("message magic is wrong")
+	ErrBadCommand      = errors.New("message command is malformed")
+	ErrBadChecksum     = errors.New("message checksum does not match its payload")
+	ErrMessageTooLarge = errors.New("message payload is too large")
+)
+
+type message struct {
+	Command string
+	Payload []byte
+}
+
+func checksum(payload []byte) []byte {
+	hash := sha256.Sum256(payload)
+	return hash[:4]
+}
+
+func writeMessage(w io.Writer, msg *message) error {
+	if len(msg.Command) == 0 || len(msg.Command) > commandLength {
+		return fmt.Errorf("%w: %q", ErrBadCommand, msg.Command)
+	}
+	if len(msg.Payload) > maxPayloadLength {
+		return fmt.Errorf("%w: %d bytes", ErrMessageTooLarge, len(msg.Payload))
+	}
+
+	frame := make([]byte, headerLength, headerLength+len(msg.Payload))
+	binary.BigEndian.PutUint32(frame[0:4], networkMagic)
+	copy(frame[4:4+commandLength], msg.Command)
+	binary.BigEndian.PutUint32(frame[4+commandLength:], uint32(len(msg.Payload)))
+	copy(frame[8+commandLength:], checksum(msg.Payload))
+	frame = append(frame, msg.Payload...)
+
+	_, err := w.Write(frame)
+	return err
+}
+
+func readMessage(r io.Reader) (*message, error) {
+	var header [headerLength]byte
+	_, err := io.ReadFull(r, header[:])
+	if err != nil {
+		return nil, err
+	}
+
+	if binary.BigEndian.Uint32(header[0:4]) != networkMagic {
+		return nil, ErrBadMagic
+	}
+
+	command := bytes.TrimRight(header[4:4+commandLength], "\x00")
+	if len(command) == 0 || bytes.IndexByte(command, 0) != -1 {
+		return nil, ErrBadCommand
+	}
+
+	length := binary.BigEndian.Uint32(header[4+commandLength:])
+	if length > maxPayloadLength {
+		return nil, fmt.Errorf("%w: %d bytes", ErrMessageTooLarge, length)
+	}
+
+	payload := make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(checksum(payload), header[8+commandLength:]) {
+		return nil, ErrBadChecksum
+	}
+
+	return &message{string(command), payload}, nil
+}
@@ -0,0 +1,173 @@
+package P2P
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var errUnreachable = errors.New("node is unreachable")
+var errListenerClosed = errors.New("listener is closed")
+
+// memoryNetwork connects nodes of one process with in-memory pipes. It can delay
+// every write and partition nodes from each other.
+type memoryNetwork struct {
+	mutex      sync.Mutex
+	listeners  map[string]*memoryListener
+	conns      map[*memoryConn]bool
+	partitions map[[2]string]bool
+	latency    time.Duration
+}
+
+func newMemoryNetwork() *memoryNetwork {
+	return &memoryNetwork{
+		listeners:  make(map[string]*memoryListener),
+		conns:      make(map[*memoryConn]bool),
+		partitions: make(map[[2]string]bool),
+	}
+}
+
+// listen returns the listener of the node reachable at address
+func (network *memoryNetwork) listen(address string) *memoryListener {
+	network.mutex.Lock()
+	defer network.mutex.Unlock()
+
+	ln := &memoryListener{address, make(chan net.Conn), make(chan struct{}), sync.Once{}}
+	network.listeners[address] = ln
+	return ln
+}
+
+// dialer returns the function the node at local uses to open connections
+func (network *memoryNetwork) dialer(local string) func(address string) (net.Conn, error) {
+	return func(address string) (net.Conn, error) {
+		network.mutex.Lock()
+		ln, ok := network.listeners[address]
+		if !ok || network.partitioned(local, address) {
+			network.mutex.Unlock()
+			return nil, errUnreachable
+		}
+
+		client, server := net.Pipe()
+		outbound := &memoryConn{client, network, local, address}
+		inbound := &memoryConn{server, network, address, local}
+		network.conns[outbound] = true
+		network.mutex.Unlock()
+
+		select {
+		case ln.conns <- inbound:
+			return outbound, nil
+		case <-ln.closed:
+			outbound.Close()
+			return nil, errUnreachable
+		}
+	}
+}
+
+// setLatency delays every write by latency
+func (network *memoryNetwork) setLatency(latency time.Duration) {
+	network.mutex.Lock()
+	defer network.mutex.Unlock()
+
+	network.latency = latency
+}
+
+func (network *memoryNetwork) delay() time.Duration {
+	network.mutex.Lock()
+	defer network.mutex.Unlock()
+
+	return network.latency
+}
+
+// partition cuts every node of one side from every node of the other, dropping their connections
+func (network *memoryNetwork) partition(side, other []string) {
+	network.mutex.Lock()
+	defer network.mutex.Unlock()
+
+	for _, a := range side {
+		for _, b := range other {
+			network.partitions[[2]string{a, b}] = true
+			network.partitions[[2]string{b, a}] = true
+		}
+	}
+
+	for conn := range network.conns {
+		if network.partitioned(conn.local, conn.remote) {
+			conn.Conn.Close()
+			delete(network.conns, conn)
+		}
+	}
+}
+
+// heal lets every node reach every other one again
+func (network *memoryNetwork) heal() {
+	network.mutex.Lock()
+	defer network.mutex.Unlock()
+
+	network.partitions = make(map[[2]string]bool)
+}
+
+// partitioned tells whether a and b are cut from each other, the caller holds the mutex
+func (network *memoryNetwork) partitioned(a, b string) bool {
+	return network.partitions[[2]string{a, b}]
+}
+
+func (network *memoryNetwork) forget(conn *memoryConn) {
+	network.mutex.Lock()
+	defer network.mutex.Unlock()
+
+	delete(network.conns, conn)
+}
+
+type memoryAddr string
+
+func (addr memoryAddr) Network() string { return "memory" }
+func (addr memoryAddr) String() string  { return string(addr) }
+
+// memoryConn is one end of a pipe between two nodes
+type memoryConn struct {
+	net.Conn
+	network *memoryNetwork
+	local   string
+	remote  string
+}
+
+func (conn *memoryConn) Write(data []byte) (int, error) {
+	if latency := conn.network.delay(); latency > 0 {
+		time.Sleep(latency)
+	}
+	return conn.Conn.Write(data)
+}
+
+func (conn *memoryConn) Close() error {
+	conn.network.forget(conn)
+	return conn.Conn.Close()
+}
+
+func (conn *memoryConn) LocalAddr() net.Addr  { return memoryAddr(conn.local) }
+func (conn *memoryConn) RemoteAddr() net.Addr { return memoryAddr(conn.remote) }
+
+type memoryListener struct {
+	address string
+	conns   chan net.Conn
+	closed  chan struct{}
+	once    sync.Once
+}
+
+func (ln *memoryListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-ln.conns:
+		return conn, nil
+	case <-ln.closed:
+		return nil, errListenerClosed
+	}
+}
+
+func (ln *memoryListener) Close() error {
+	ln.once.Do(func() { close(ln.closed) })
+	return nil
+}
+
+func (ln *memoryListener) Addr() net.Addr {
+	return memoryAddr(ln.address)
+}
@@ -0,0 +1,273 @@
+package P2P
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const dialTimeout = 5 * time.Second
+const writeTimeout = 30 * time.Second
+
+// messages queued for a peer before senders block
+const sendQueueLength = 64
+
+// addr messages a peer can send at once, see allowAddr
+const maxAddrBurst = 10
+
+// peer is a long-lived connection to another node, one goroutine reads and
+// handles its messages in order while another writes the queued ones
+type peer struct {
+	address string
+	inbound bool
+	conn    net.Conn
+	manager *PeerManager
+	send    chan *message
+	done    chan struct{}
+	written chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+
+	// handshaken is closed once both sides sent their version and acknowledged the other's
+	handshaken chan struct{}
+
+	mutex     sync.Mutex
+	version   *version
+	verack    bool
+	pending   []*message
+	score     int
+	pingNonce uint64
+	pingSent  time.Time
+
+	addrTokens   int
+	addrRefilled time.Time
+}
+
+func newPeer(manager *PeerManager, conn net.Conn, address string, inbound bool) *peer {
+	p := &peer{
+		address:    address,
+		inbound:    inbound,
+		conn:       conn,
+		manager:    manager,
+		send:       make(chan *message, sendQueueLength),
+		done:       make(chan struct{}),
+		written:    make(chan struct{}),
+		stopped:    make(chan struct{}),
+		handshaken: make(chan struct{}),
+		addrTokens: maxAddrBurst,
+	}
+
+	go p.readLoop()
+	go p.writeLoop()
+	go p.keepAlive()
+	return p
+}
+
+// Send queues the message. Until the handshake is done only the handshake and
+// keepalive messages go out, the others wait for it. Messages to a disconnected peer are dropped.
+func (p *peer) Send(msg *message) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.isHandshaken() && !isControlCommand(msg.Command) {
+		p.pending = append(p.pending, msg)
+		return
+	}
+	p.queue(msg)
+}
+
+func (p *peer) queue(msg *message) {
+	select {
+	case p.send <- msg:
+	case <-p.done:
+	}
+}
+
+func isControlCommand(command string) bool {
+	return command == "version" || command == "verack" || command == "ping" || command == "pong"
+}
+
+func (p *peer) isHandshaken() bool {
+	select {
+	case <-p.handshaken:
+		return true
+	default:
+		return false
+	}
+}
+
+// completeHandshake releases the messages held back once both version messages were acknowledged
+func (p *peer) completeHandshake() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.version == nil || !p.verack || p.isHandshaken() {
+		return
+	}
+
+	close(p.handshaken)
+	for _, msg := range p.pending {
+		p.queue(msg)
+	}
+	p.pending = nil
+}
+
+func (p *peer) close() {
+	p.once.Do(func() {
+		close(p.done)
+		p.manager.remove(p)
+	})
+}
+
+func (p *peer) closed() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *peer) readLoop() {
+	defer close(p.stopped)
+	defer p.close()
+
+	for {
+		msg, err := readMessage(p.conn)
+		if err != nil {
+			if p.closed() {
+				return
+			}
+			if err != io.EOF {
+				p.manager.logger.Printf("Disconnecting %s: %s", p.address, err)
+			}
+			if score := frameScore(err); score > 0 {
+				p.manager.Misbehave(p, score, err)
+			}
+			return
+		}
+
+		p.manager.handle(p, msg)
+	}
+}
+
+// writeLoop writes queued messages until the peer is closed, then flushes the queue and hangs up
+func (p *peer) writeLoop() {
+	defer close(p.written)
+	defer p.conn.Close()
+
+	for {
+		select {
+		case msg := <-p.send:
+			if !p.write(msg) {
+				p.close()
+				return
+			}
+		case <-p.done:
+			for {
+				select {
+				case msg := <-p.send:
+					if !p.write(msg) {
+						return
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (p *peer) write(msg *message) bool {
+	p.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+
+	err := writeMessage(p.conn, msg)
+	if err != nil {
+		p.manager.logger.Printf("Sending %s to %s failed: %s", msg.Command, p.address, err)
+		return false
+	}
+	return true
+}
+
+// keepAlive drops the peer if it does not finish the handshake in time, then pings it
+// every ping interval and drops it when the previous ping was not answered
+func (p *peer) keepAlive() {
+	handshakeTimer := time.NewTimer(handshakeTimeout)
+	defer handshakeTimer.Stop()
+
+	select {
+	case <-p.handshaken:
+	case <-p.done:
+		return
+	case <-handshakeTimer.C:
+		p.manager.logger.Printf("Disconnecting %s: no handshake", p.address)
+		p.close()
+		return
+	}
+
+	ticker := time.NewTicker(p.manager.pingInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+
+		p.mutex.Lock()
+		waiting := !p.pingSent.IsZero()
+		if !waiting {
+			p.pingNonce = newNonce()
+			p.pingSent = time.Now()
+		}
+		nonce := p.pingNonce
+		p.mutex.Unlock()
+
+		if waiting {
+			p.manager.logger.Printf("Disconnecting %s: ping timeout", p.address)
+			p.close()
+			return
+		}
+		p.Send(&message{"ping", encodeNonce(nonce)})
+	}
+}
+
+// pong records the answer to the last ping
+func (p *peer) pong(nonce uint64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.pingSent.IsZero() && nonce == p.pingNonce {
+		p.pingSent = time.Time{}
+	}
+}
+
+// allowAddr tells whether the peer may send another addr message. It gets one every
+// addr interval, the pace at which this node asks for addresses, and can save up
+// maxAddrBurst of them for the answers to the getaddr sent on connecting.
+func (p *peer) allowAddr() bool {
+	interval := p.manager.addrInterval()
+	now := time.Now()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.addrRefilled.IsZero() || p.addrTokens == maxAddrBurst {
+		p.addrRefilled = now
+	}
+	if refills := int(now.Sub(p.addrRefilled) / interval); refills > 0 {
+		p.addrTokens += refills
+		if p.addrTokens > maxAddrBurst {
+			p.addrTokens = maxAddrBurst
+		}
+		p.addrRefilled = p.addrRefilled.Add(time.Duration(refills) * interval)
+	}
+
+	if p.addrTokens == 0 {
+		return false
+	}
+	p.addrTokens--
+	return true
+}
@@ -0,0 +1,442 @@
+package P2P
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PeerConfig limits the connections of a node, it can be overridden from config.yaml
+type PeerConfig struct {
+	MaxInbound   int      `yaml:"maxInbound"`
+	MaxOutbound  int      `yaml:"maxOutbound"`
+	BanDuration  int      `yaml:"banDuration"`
+	PingInterval int      `yaml:"pingInterval"`
+	AddrInterval int      `yaml:"addrInterval"`
+	Seeds        []string `yaml:"seeds"`
+}
+
+// PeerSettings is the configuration new peer managers start with, durations are in seconds.
+// A new node knows nobody but the seeds, and asks its peers for more addresses.
+var PeerSettings = PeerConfig{
+	MaxInbound:   8,
+	MaxOutbound:  8,
+	BanDuration:  24 * 60 * 60,
+	PingInterval: 60,
+	AddrInterval: 5 * 60,
+	Seeds:        []string{"localhost:3000"},
+}
+
+// Validate checks that the limits and intervals can be used, the intervals start tickers
+func (config PeerConfig) Validate() error {
+	if config.MaxInbound < 0 || config.MaxOutbound < 0 {
+		return errors.New("The peer limits cannot be negative !!!")
+	}
+	if config.BanDuration < 0 {
+		return errors.New("The ban duration cannot be negative !!!")
+	}
+	if config.PingInterval <= 0 || config.AddrInterval <= 0 {
+		return errors.New("The ping and addr intervals have to be positive !!!")
+	}
+
+	return nil
+}
+
+const handshakeTimeout = 10 * time.Second
+
+// a peer is banned once its misbehavior adds up to banThreshold
+const banThreshold = 100
+
+// scores of the misbehaviors
+const (
+	scoreInvalid     = 100
+	scoreOversized   = 100
+	scoreMalformed   = 20
+	scoreBadFrame    = 20
+	scoreProtocol    = 10
+	scoreUnsolicited = 1
+)
+
+// consecutive failed dials after which a node is forgotten
+const maxDialFailures = 3
+
+// handlers return these so the peer manager can score the sender
+var (
+	errMalformed = errors.New("payload is malformed")
+	errInvalid   = errors.New("peer relayed invalid data")
+)
+
+var errTooManyPeers = errors.New("too many peers")
+var errBanned = errors.New("peer is banned")
+
+// PeerManager connects to peers, performs the version handshake, keeps the
+// connections alive and bans peers that misbehave
+type PeerManager struct {
+	config PeerConfig
+
+	mutex     sync.Mutex
+	peers     map[*peer]bool
+	addresses map[string]*peer
+	bans      map[string]time.Time
+	failures  map[string]int
+
+	// handler gets every message once the peer finished its handshake, and the version message itself
+	handler func(p *peer, msg *message) error
+	// localVersion returns the version message sent to new peers
+	localVersion func() version
+	// dial opens the connection to a peer
+	dial func(address string) (net.Conn, error)
+	// logger is shared with the server owning the peers
+	logger *log.Logger
+}
+
+func NewPeerManager(config PeerConfig) *PeerManager {
+	return &PeerManager{
+		config:       config,
+		peers:        make(map[*peer]bool),
+		addresses:    make(map[string]*peer),
+		bans:         make(map[string]time.Time),
+		failures:     make(map[string]int),
+		localVersion: func() version { return version{nodeVersion, 0, ""} },
+		dial: func(address string) (net.Conn, error) {
+			return net.DialTimeout(protocol, address, dialTimeout)
+		},
+		logger: log.New(os.Stdout, "", 0),
+	}
+}
+
+// connect returns the peer listening on address, dialing it and starting the handshake
+// when there is no connection yet. The dial happens without the mutex so that a slow
+// node does not hold up the other peers.
+func (m *PeerManager) connect(address string) (*peer, error) {
+	keys := banKeys(address)
+
+	m.mutex.Lock()
+	p, err := m.canDial(address, keys)
+	m.mutex.Unlock()
+	if p != nil || err != nil {
+		return p, err
+	}
+
+	conn, err := m.dial(address)
+	var remote []string
+	if err == nil {
+		remote = banKeys(conn.RemoteAddr().String())
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err != nil {
+		m.failures[address]++
+		return nil, err
+	}
+	delete(m.failures, address)
+
+	// another connection may have been made or the node banned while dialing
+	p, err = m.canDial(address, keys)
+	if err == nil && p == nil && m.isBanned(remote...) {
+		err = errBanned
+	}
+	if p != nil || err != nil {
+		conn.Close()
+		return p, err
+	}
+
+	p = newPeer(m, conn, address, false)
+	m.peers[p] = true
+	m.addresses[address] = p
+
+	p.Send(&message{"version", GobEncode(m.localVersion())})
+	return p, nil
+}
+
+// canDial returns the peer already connected to address, or why no new connection
+// to it can be made. keys are the ban keys of address, the caller holds the mutex.
+func (m *PeerManager) canDial(address string, keys []string) (*peer, error) {
+	if p, ok := m.addresses[address]; ok {
+		return p, nil
+	}
+	if m.isBanned(keys...) {
+		return nil, errBanned
+	}
+	if m.count(false) >= m.config.MaxOutbound {
+		return nil, errTooManyPeers
+	}
+	return nil, nil
+}
+
+// accept takes an inbound connection. The peer keeps the address it connected from, the one
+// it claims to listen on is not trusted and only reached by dialing it.
+func (m *PeerManager) accept(conn net.Conn) {
+	keys := banKeys(conn.RemoteAddr().String())
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	err := errTooManyPeers
+	if m.isBanned(keys...) {
+		err = errBanned
+	} else if m.count(true) < m.config.MaxInbound {
+		err = nil
+	}
+	if err != nil {
+		m.logger.Printf("Refusing %s: %s", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+
+	p := newPeer(m, conn, conn.RemoteAddr().String(), true)
+	m.peers[p] = true
+}
+
+// count returns the number of inbound or outbound peers, the caller holds the mutex
+func (m *PeerManager) count(inbound bool) int {
+	count := 0
+	for p := range m.peers {
+		if p.inbound == inbound {
+			count++
+		}
+	}
+	return count
+}
+
+// gaveUp tells whether dialing address failed too many times in a row to keep trying
+func (m *PeerManager) gaveUp(address string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.failures[address] >= maxDialFailures
+}
+
+func (m *PeerManager) remove(p *peer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.peers, p)
+	if m.addresses[p.address] == p {
+		delete(m.addresses, p.address)
+	}
+}
+
+// isBanned tells whether any of the ban keys is banned, the caller holds the mutex
+func (m *PeerManager) isBanned(keys ...string) bool {
+	banned := false
+	for _, key := range keys {
+		until, ok := m.bans[key]
+		if ok && time.Now().After(until) {
+			delete(m.bans, key)
+			continue
+		}
+		banned = banned || ok
+	}
+	return banned
+}
+
+// banKeys returns the keys a node at address is banned under. Bans are kept by IP rather
+// than by the address a peer claims to listen on, which it could change to come back, and
+// names are resolved so that dialing one finds the bans of its IPs. Every node of a local
+// network shares the loopback IP, so loopback nodes are banned by port instead: a node
+// dialed on a loopback address stays banned, an inbound one is only disconnected.
+func banKeys(address string) []string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return []string{address}
+	}
+
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		ips, err = net.LookupIP(host)
+		if err != nil {
+			return []string{host}
+		}
+	}
+
+	var keys []string
+	seen := make(map[string]bool)
+	for _, ip := range ips {
+		key := ip.String()
+		if ip.IsLoopback() {
+			key = net.JoinHostPort("loopback", port)
+		}
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Misbehave adds to the ban score of the peer and bans its host for the ban duration once it reaches the threshold
+func (m *PeerManager) Misbehave(p *peer, score int, reason error) {
+	p.mutex.Lock()
+	p.score += score
+	total := p.score
+	p.mutex.Unlock()
+
+	m.logger.Printf("Peer %s misbehaved (+%d, %d in total): %s", p.address, score, total, reason)
+	if total < banThreshold {
+		return
+	}
+
+	keys := banKeys(p.conn.RemoteAddr().String())
+	m.mutex.Lock()
+	for _, key := range keys {
+		m.bans[key] = time.Now().Add(time.Duration(m.config.BanDuration) * time.Second)
+	}
+	m.mutex.Unlock()
+
+	m.logger.Printf("Banned %s for %d seconds", strings.Join(keys, ", "), m.config.BanDuration)
+	p.close()
+}
+
+// Close disconnects every peer once the messages queued for it are written,
+// giving peers still in the handshake the time to finish it. It returns when
+// no message of these peers is being handled anymore.
+func (m *PeerManager) Close() {
+	m.mutex.Lock()
+	var connected []*peer
+	for p := range m.peers {
+		connected = append(connected, p)
+	}
+	m.mutex.Unlock()
+
+	for _, p := range connected {
+		select {
+		case <-p.handshaken:
+		case <-p.done:
+		case <-time.After(handshakeTimeout):
+		}
+
+		p.close()
+		<-p.written
+		<-p.stopped
+	}
+}
+
+func (m *PeerManager) pingInterval() time.Duration {
+	return time.Duration(m.config.PingInterval) * time.Second
+}
+
+func (m *PeerManager) addrInterval() time.Duration {
+	return time.Duration(m.config.AddrInterval) * time.Second
+}
+
+// handle runs the handshake and keepalive messages and passes the others to the handler
+func (m *PeerManager) handle(p *peer, msg *message) {
+	switch msg.Command {
+	case "version":
+		m.handleVersion(p, msg)
+		return
+	case "verack":
+		p.mutex.Lock()
+		p.verack = true
+		p.mutex.Unlock()
+		p.completeHandshake()
+		return
+	case "ping":
+		p.Send(&message{"pong", msg.Payload})
+		return
+	case "pong":
+		if len(msg.Payload) == 8 {
+			p.pong(binary.BigEndian.Uint64(msg.Payload))
+		}
+		return
+	}
+
+	if !p.isHandshaken() {
+		m.Misbehave(p, scoreProtocol, fmt.Errorf("%s before the handshake", msg.Command))
+		return
+	}
+	if m.handler == nil {
+		return
+	}
+
+	err := m.handler(p, msg)
+	if err != nil {
+		m.Misbehave(p, handlerScore(err), err)
+	}
+}
+
+func (m *PeerManager) handleVersion(p *peer, msg *message) {
+	var payload version
+	err := GobDecode(msg.Payload, &payload)
+	if err != nil {
+		m.Misbehave(p, scoreMalformed, fmt.Errorf("%w: %v", errMalformed, err))
+		return
+	}
+	if payload.Version != nodeVersion {
+		m.logger.Printf("Disconnecting %s: protocol version %d is not %d", p.address, payload.Version, nodeVersion)
+		p.close()
+		return
+	}
+
+	p.mutex.Lock()
+	duplicate := p.version != nil
+	if !duplicate {
+		p.version = &payload
+	}
+	p.mutex.Unlock()
+
+	if duplicate {
+		m.Misbehave(p, scoreProtocol, errors.New("duplicate version"))
+		return
+	}
+
+	if p.inbound {
+		p.Send(&message{"version", GobEncode(m.localVersion())})
+	}
+	p.Send(&message{"verack", nil})
+	p.completeHandshake()
+
+	if m.handler != nil {
+		err = m.handler(p, msg)
+		if err != nil {
+			m.Misbehave(p, handlerScore(err), err)
+		}
+	}
+}
+
+// frameScore scores a frame that could not be read, the connection is dropped either way
+func frameScore(err error) int {
+	if errors.Is(err, ErrMessageTooLarge) {
+		return scoreOversized
+	}
+	if errors.Is(err, ErrBadMagic) || errors.Is(err, ErrBadCommand) || errors.Is(err, ErrBadChecksum) {
+		return scoreBadFrame
+	}
+	return 0
+}
+
+// handlerScore scores an error returned by a message handler
+func handlerScore(err error) int {
+	if errors.Is(err, errInvalid) {
+		return scoreInvalid
+	}
+	if errors.Is(err, errMalformed) {
+		return scoreMalformed
+	}
+	return scoreUnsolicited
+}
+
+func newNonce() uint64 {
+	var buf [8]byte
+	_, err := rand.Read(buf[:])
+	if err != nil {
+		return uint64(time.Now().UnixNano())
+	}
+	return binary.BigEndian.Uint64(buf[:])
+}
+
+func encodeNonce(nonce uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, nonce)
+	return buf
+}
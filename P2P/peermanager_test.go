@@ -0,0 +1,293 @@
+package P2P
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// inboundFrom returns the listening end of a connection from address and the dialing end
+func inboundFrom(network *memoryNetwork, address string) (net.Conn, net.Conn) {
+	client, server := net.Pipe()
+	return &memoryConn{server, network, "10.0.0.1:3000", address}, &memoryConn{client, network, address, "10.0.0.1:3000"}
+}
+
+// refused tells whether the listening end hung up on the dialing end
+func refused(conn net.Conn) bool {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := conn.Read(make([]byte, 1))
+	return err == io.EOF || err == io.ErrClosedPipe
+}
+
+func TestBansAreKeptByHost(t *testing.T) {
+	network := newMemoryNetwork()
+	m := NewPeerManager(simulationSettings)
+	defer m.Close()
+
+	conn, remote := inboundFrom(network, "10.0.0.2:4000")
+	defer remote.Close()
+	m.accept(conn)
+
+	var p *peer
+	m.mutex.Lock()
+	for connected := range m.peers {
+		p = connected
+	}
+	m.mutex.Unlock()
+
+	m.Misbehave(p, banThreshold, errInvalid)
+
+	conn, remote = inboundFrom(network, "10.0.0.2:5000")
+	defer remote.Close()
+	m.accept(conn)
+	if !refused(remote) {
+		t.Fatal("a banned host connected again from another port")
+	}
+
+	if _, err := m.connect("10.0.0.2:3000"); err != errBanned {
+		t.Fatalf("dialing a banned host returned %v", err)
+	}
+
+	conn, remote = inboundFrom(network, "10.0.0.3:4000")
+	defer remote.Close()
+	m.accept(conn)
+	m.mutex.Lock()
+	count := m.count(true)
+	m.mutex.Unlock()
+	if count != 1 {
+		t.Fatalf("%d inbound peers instead of 1", count)
+	}
+}
+
+func TestBanKeys(t *testing.T) {
+	tests := []struct {
+		address string
+		keys    []string
+	}{
+		{"10.0.0.2:4000", []string{"10.0.0.2"}},
+		{"[2001:db8::1]:4000", []string{"2001:db8::1"}},
+		{"127.0.0.1:3001", []string{"loopback:3001"}},
+		{"[::1]:3001", []string{"loopback:3001"}},
+		{"localhost:3001", []string{"loopback:3001"}},
+	}
+
+	for _, test := range tests {
+		if keys := banKeys(test.address); !reflect.DeepEqual(keys, test.keys) {
+			t.Fatalf("%s is banned under %v instead of %v", test.address, keys, test.keys)
+		}
+	}
+}
+
+func TestLoopbackBansKeepThePort(t *testing.T) {
+	sim := newSimulation(t, 3)
+	m := sim.nodes[0].server.peers
+
+	p, err := m.connect(sim.nodes[1].address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Misbehave(p, banThreshold, errInvalid)
+
+	for _, address := range []string{sim.nodes[1].address, "127.0.0.1:3001"} {
+		if _, err := m.connect(address); err != errBanned {
+			t.Fatalf("dialing the banned node at %s returned %v", address, err)
+		}
+	}
+
+	// the other nodes on the same machine are still reachable
+	if _, err := m.connect(sim.nodes[2].address); err != nil {
+		t.Fatalf("dialing another local node returned %v", err)
+	}
+}
+
+func TestDialingDoesNotBlockThePeers(t *testing.T) {
+	network := newMemoryNetwork()
+	m := NewPeerManager(simulationSettings)
+	defer m.Close()
+
+	release := make(chan struct{})
+	m.dial = func(address string) (net.Conn, error) {
+		<-release
+		return nil, errUnreachable
+	}
+	dialed := make(chan error)
+	go func() {
+		_, err := m.connect("10.0.0.4:3000")
+		dialed <- err
+	}()
+
+	accepted := make(chan struct{})
+	go func() {
+		conn, remote := inboundFrom(network, "10.0.0.2:4000")
+		defer remote.Close()
+		m.accept(conn)
+		close(accepted)
+	}()
+
+	select {
+	case <-accepted:
+	case <-time.After(time.Second):
+		t.Fatal("accepting waited for the dial")
+	}
+
+	close(release)
+	if err := <-dialed; err != errUnreachable {
+		t.Fatalf("the dial returned %v", err)
+	}
+}
+
+func TestAddrMessagesAreRateLimited(t *testing.T) {
+	config := simulationSettings
+	config.AddrInterval = 60
+	p := &peer{manager: NewPeerManager(config), addrTokens: maxAddrBurst}
+
+	for i := 0; i < maxAddrBurst; i++ {
+		if !p.allowAddr() {
+			t.Fatalf("addr message %d of the burst was refused", i)
+		}
+	}
+	if p.allowAddr() {
+		t.Fatal("an addr message beyond the burst was allowed")
+	}
+
+	p.addrRefilled = p.addrRefilled.Add(-time.Minute)
+	if !p.allowAddr() || p.allowAddr() {
+		t.Fatal("one addr message is not allowed per addr interval")
+	}
+}
+
+func TestPeerConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(config *PeerConfig)
+		valid  bool
+	}{
+		{"defaults", func(config *PeerConfig) {}, true},
+		{"no inbound peers", func(config *PeerConfig) { config.MaxInbound = 0 }, true},
+		{"negative outbound peers", func(config *PeerConfig) { config.MaxOutbound = -1 }, false},
+		{"negative ban duration", func(config *PeerConfig) { config.BanDuration = -1 }, false},
+		{"no ping interval", func(config *PeerConfig) { config.PingInterval = 0 }, false},
+		{"no addr interval", func(config *PeerConfig) { config.AddrInterval = 0 }, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := PeerSettings
+			test.change(&config)
+			if err := config.Validate(); (err == nil) != test.valid {
+				t.Fatalf("Validate returned %v", err)
+			}
+		})
+	}
+}
+
+// handshake sends the version and verack of a node claiming to listen on address
+func handshake(t *testing.T, conn net.Conn, v version) {
+	t.Helper()
+
+	for _, msg := range []*message{{"version", GobEncode(v)}, {"verack", nil}} {
+		if err := writeMessage(conn, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRepliesGoToTheRequestingPeer(t *testing.T) {
+	sim := newSimulation(t, 2)
+	m := sim.nodes[0].server.peers
+
+	// the peer claims to be node 1 and asks for headers on its behalf
+	conn, remote := inboundFrom(sim.network, "10.0.0.5:4000")
+	defer remote.Close()
+	m.accept(conn)
+	handshake(t, remote, version{nodeVersion, 0, sim.nodes[1].address})
+	err := writeMessage(remote, &message{"getheaders", GobEncode(GetHeaders{sim.nodes[1].address, nil})})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remote.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		msg, err := readMessage(remote)
+		if err != nil {
+			t.Fatalf("no headers came back on the connection: %s", err)
+		}
+		if msg.Command == "headers" {
+			break
+		}
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for address, p := range m.addresses {
+		if p.inbound {
+			t.Fatalf("the inbound peer took the address %s", address)
+		}
+	}
+}
+
+func TestIncompatibleVersionsAreRefused(t *testing.T) {
+	sim := newSimulation(t, 1)
+	m := sim.nodes[0].server.peers
+
+	conn, remote := inboundFrom(sim.network, "10.0.0.6:4000")
+	defer remote.Close()
+	m.accept(conn)
+	err := writeMessage(remote, &message{"version", GobEncode(version{nodeVersion + 1, 0, ""})})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !refused(remote) {
+		t.Fatal("a peer with another protocol version stayed connected")
+	}
+}
+
+// lockedBuffer collects the output of a logger written to by several goroutines
+type lockedBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+func TestPeersLogThroughTheServer(t *testing.T) {
+	server := NewServer("", "", nil, NewAddressBook("", nil), simulationSettings)
+	defer server.Close()
+	var output lockedBuffer
+	server.logger.SetOutput(&output)
+
+	network := newMemoryNetwork()
+	conn, remote := inboundFrom(network, "10.0.0.7:4000")
+	defer remote.Close()
+	m := server.peers
+	m.accept(conn)
+
+	var p *peer
+	m.mutex.Lock()
+	for connected := range m.peers {
+		p = connected
+	}
+	m.mutex.Unlock()
+	m.Misbehave(p, banThreshold, errInvalid)
+
+	logged := output.String()
+	if !strings.Contains(logged, "Peer 10.0.0.7:4000 misbehaved") || !strings.Contains(logged, "Banned 10.0.0.7") {
+		t.Fatalf("the server logger got %q", logged)
+	}
+}
@@ -4,26 +4,89 @@ import (
 	"COMP5567-BlockChain/features"
 	"bytes"
 	"encoding/gob"
-	"encoding/hex"
+	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"log"
+	"math/rand"
 	"net"
+	"os"
+	"sync"
+	"time"
 )
 
 const protocol = "tcp"
 const nodeVersion = 1
-const commandLength = 12
 
-var nodeAddress string
-var miningAddress string
-var knownNodes = []string{"localhost:3000"}
-var blocksInTransit = [][]byte{}
-var mempool = make(map[string]features.Transaction)
+// Server is a node of the network. It owns its peers, the nodes it knows and its mempool,
+// so several servers can run in one process.
+type Server struct {
+	address       string
+	miningAddress string
+	blockchain    *features.BlockChain
+	peers         *PeerManager
+	addresses     *AddressBook
+	logger        *log.Logger
+	done          chan struct{}
+	closeOnce     sync.Once
+
+	mutex           sync.Mutex
+	blocksInTransit [][]byte
+	mempool         map[string]features.Transaction
+
+	// mining is held while a block is mined so transactions arriving meanwhile wait for it
+	mining sync.Mutex
+	// wakeMiner asks the miner to mine the mempool, minerStopped is closed once it returned
+	wakeMiner    chan struct{}
+	minerStopped chan struct{}
+}
+
+// NewServer returns a node listening on address, or a client only sending messages when
+// blockchain is nil. Blocks mined by a node pay miningAddress, it does not mine when that is empty.
+func NewServer(address, miningAddress string, blockchain *features.BlockChain, addresses *AddressBook, config PeerConfig) *Server {
+	server := &Server{
+		address:       address,
+		miningAddress: miningAddress,
+		blockchain:    blockchain,
+		peers:         NewPeerManager(config),
+		addresses:     addresses,
+		logger:        log.New(os.Stdout, "", 0),
+		done:          make(chan struct{}),
+		mempool:       make(map[string]features.Transaction),
+		wakeMiner:     make(chan struct{}, 1),
+		minerStopped:  make(chan struct{}),
+	}
+
+	server.peers.logger = server.logger
+
+	if blockchain != nil {
+		server.peers.localVersion = func() version {
+			return version{nodeVersion, blockchain.GetBestHeight(), address}
+		}
+		server.peers.handler = func(p *peer, msg *message) error {
+			if msg.Command == "addr" && !p.allowAddr() {
+				return errors.New("too many addr messages")
+			}
+			return server.dispatch(p, msg)
+		}
+	}
+
+	if blockchain != nil && miningAddress != "" {
+		go server.miner()
+	} else {
+		close(server.minerStopped)
+	}
+
+	return server
+}
 
-type Address struct {
-	AddressList []string
+type GetAddr struct {
+	AddressFrom string
+}
+
+// Addr shares known node addresses with the time each of them was last seen
+type Addr struct {
+	AddressFrom string
+	Addresses   []KnownAddress
 }
 
 type BlockSender struct {
@@ -31,8 +94,15 @@ type BlockSender struct {
 	Block       []byte
 }
 
-type BlockSenderAddr struct {
+// GetHeaders asks for the headers following the first Locator hash the peer has on its main chain
+type GetHeaders struct {
+	AddressFrom string
+	Locator     [][]byte
+}
+
+type Headers struct {
 	AddressFrom string
+	Headers     [][]byte
 }
 
 type Data struct {
@@ -41,6 +111,17 @@ type Data struct {
 	ID          []byte
 }
 
+// GetProof asks a full node for the Merkle proof of a transaction
+type GetProof struct {
+	AddressFrom string
+	TXID        []byte
+}
+
+type Proof struct {
+	AddressFrom string
+	Proof       []byte
+}
+
 type Inv struct {
 	AddressFrom string
 	Type        string
@@ -58,54 +139,53 @@ type version struct {
 	AddressFrom string
 }
 
-func Command2Bytes(command string) []byte {
-	var bytes [commandLength]byte
-	for i, j := range command {
-		bytes[i] = byte(j)
-	}
-	return bytes[:]
-}
-
-func Bytes2Command(bytes []byte) string {
-	var command []byte
-
-	for _, b := range bytes {
-		if b != 0x0 {
-			command = append(command, b)
+func (server *Server) RequestBlock() {
+	for _, node := range server.KnownNodes() {
+		if p := server.peerAt(node); p != nil {
+			server.SendGetHeaders(p, server.blockchain.HeaderLocator())
 		}
 	}
-	return fmt.Sprintf("%s", command)
 }
 
-func ExtractCommand(request []byte) []byte {
-	return request[:commandLength]
+// KnownNodes returns the nodes this server talks to, the most recently seen
+// first and no more than it can have peers
+func (server *Server) KnownNodes() []string {
+	config := server.peers.config
+	return server.addresses.Select(config.MaxInbound + config.MaxOutbound)
 }
 
-func RequestBlock() {
-	for _, node := range knownNodes {
-		SendGetBlock(node)
-	}
+// isSeed tells whether this server is a seed node, seeds relay transactions to the miners
+func (server *Server) isSeed() bool {
+	return server.addresses.isSeed(server.address)
 }
 
-func GetKnownNodes() []string {
-	return knownNodes
+func (server *Server) SendGetAddr(p *peer) {
+	payload := GobEncode(GetAddr{server.address})
+	p.Send(&message{"getaddr", payload})
 }
 
-func SendAddress(address string) {
-	nodes := Address{knownNodes}
-	nodes.AddressList = append(nodes.AddressList, nodeAddress)
-	payload := GobEncode(nodes)
-	request := append(Command2Bytes("Address"), payload...)
+// SendAddr shares the most recently seen addresses, this server's own included
+func (server *Server) SendAddr(p *peer) {
+	addresses := server.addresses.Addresses()
+	if len(addresses) >= maxAddrPerMessage {
+		addresses = addresses[:maxAddrPerMessage-1]
+	}
+	if server.address != "" {
+		addresses = append(addresses, KnownAddress{server.address, time.Now().Unix()})
+	}
 
-	SendData(address, request)
+	payload := GobEncode(Addr{server.address, addresses})
+	p.Send(&message{"addr", payload})
 }
 
-func SendBlock(address string, b *features.Block) {
-	data := BlockSender{nodeAddress, b.Serialize()}
+func (server *Server) SendBlock(p *peer, b *features.Block) {
+	data := BlockSender{server.address, b.Serialize()}
 	payload := GobEncode(data)
-	request := append(Command2Bytes("block"), payload...)
+	p.Send(&message{"block", payload})
+}
 
-	SendData(address, request)
+func GobDecode(data []byte, value interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(value)
 }
 
 func GobEncode(data interface{}) []byte {
@@ -120,325 +200,569 @@ func GobEncode(data interface{}) []byte {
 	return buff.Bytes()
 }
 
-func SendData(address string, data []byte) {
-	connection, err := net.Dial(protocol, address)
-	if err != nil {
-		fmt.Printf("%s is not available\n", address)
-		var updatedNodes []string
+// SendData queues a message for the node at address, connecting to it first if needed
+func (server *Server) SendData(address, command string, payload []byte) {
+	if p := server.peerAt(address); p != nil {
+		p.Send(&message{command, payload})
+	}
+}
 
-		for _, node := range knownNodes {
-			if node != address {
-				updatedNodes = append(updatedNodes, node)
-			}
+// peerAt returns the peer listening on address, connecting to it first if needed, or nil
+// when it is not available. Nodes that cannot be reached several times in a row or are
+// banned are forgotten. Replies go to the peer a request came from rather than to an address.
+func (server *Server) peerAt(address string) *peer {
+	p, err := server.peers.connect(address)
+	if err != nil {
+		server.logger.Printf("%s is not available: %s", address, err)
+		if err == errBanned || server.peers.gaveUp(address) {
+			server.addresses.Remove(address)
 		}
-
-		knownNodes = updatedNodes
-		return
+		return nil
 	}
-	defer connection.Close()
-	_, err = io.Copy(connection, bytes.NewReader(data))
-	if err != nil {
-		log.Panic(err)
+
+	return p
+}
+
+// Close stops the address gossip and the miner, disconnects every peer once the
+// messages queued for it are written and saves the address book
+func (server *Server) Close() {
+	server.closeOnce.Do(func() { close(server.done) })
+	<-server.minerStopped
+	server.peers.Close()
+	server.addresses.Save()
+}
+
+func (server *Server) SendGetHeaders(p *peer, locator [][]byte) {
+	payload := GobEncode(GetHeaders{server.address, locator})
+	p.Send(&message{"getheaders", payload})
+}
+
+func (server *Server) SendHeaders(p *peer, headers []*features.BlockHeader) {
+	data := Headers{server.address, nil}
+	for _, header := range headers {
+		data.Headers = append(data.Headers, header.Serialize())
 	}
+	payload := GobEncode(data)
+	p.Send(&message{"headers", payload})
 }
 
-func SendInv(address, kind string, items [][]byte) {
-	inventory := Inv{nodeAddress, kind, items}
-	payload := GobEncode(inventory)
-	request := append(Command2Bytes("Inv"), payload...)
+func (server *Server) SendGetProof(p *peer, txID []byte) {
+	payload := GobEncode(GetProof{server.address, txID})
+	p.Send(&message{"getproof", payload})
+}
 
-	SendData(address, request)
+func (server *Server) SendProof(p *peer, proof *features.MerkleProof) {
+	payload := GobEncode(Proof{server.address, proof.Serialize()})
+	p.Send(&message{"proof", payload})
 }
 
-func SendGetData(address, kind string, id []byte) {
-	payload := GobEncode(Data{nodeAddress, kind, id})
-	request := append(Command2Bytes("Data"), payload...)
+func (server *Server) SendInv(p *peer, kind string, items [][]byte) {
+	inventory := Inv{server.address, kind, items}
+	payload := GobEncode(inventory)
+	p.Send(&message{"Inv", payload})
+}
 
-	SendData(address, request)
+func (server *Server) SendGetData(p *peer, kind string, id []byte) {
+	payload := GobEncode(Data{server.address, kind, id})
+	p.Send(&message{"Data", payload})
 }
 
-func SendTX(address string, transactions *features.Transaction) {
-	data := TX{nodeAddress, transactions.Serialize()}
-	payload := GobEncode(data)
-	request := append(Command2Bytes("TX"), payload...)
+// SendTX sends the transaction to the node at address, which is how clients submit them
+func (server *Server) SendTX(address string, transaction *features.Transaction) {
+	server.SendData(address, "TX", server.txPayload(transaction))
+}
 
-	SendData(address, request)
+func (server *Server) txPayload(transaction *features.Transaction) []byte {
+	data := TX{server.address, transaction.Serialize()}
+	return GobEncode(data)
 }
 
-func SendVersion(address string, blockchain *features.BlockChain) {
-	bestHeight := blockchain.GetBestHeight()
-	payload := GobEncode(version{nodeVersion, bestHeight, nodeAddress})
+func (server *Server) HandleGetAddr(p *peer, request []byte) error {
+	var payload GetAddr
 
-	request := append(Command2Bytes("version"), payload...)
+	err := GobDecode(request, &payload)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errMalformed, err)
+	}
 
-	SendData(address, request)
+	server.SendAddr(p)
+
+	return nil
 }
 
-func HandleAddress(request []byte) {
-	var buff bytes.Buffer
-	var payload Address
+func (server *Server) HandleAddr(p *peer, request []byte) error {
+	var payload Addr
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := GobDecode(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return fmt.Errorf("%w: %v", errMalformed, err)
 	}
 
-	knownNodes = append(knownNodes, payload.AddressList...)
-	fmt.Printf("There are %d known nodes now!\n", len(knownNodes))
-	RequestBlock()
+	if len(payload.Addresses) > maxAddrPerMessage {
+		return fmt.Errorf("%w: %d addresses", errInvalid, len(payload.Addresses))
+	}
+
+	learned := 0
+	for _, known := range payload.Addresses {
+		if known.Address != server.address && server.addresses.Add(known.Address, known.LastSeen) {
+			learned++
+		}
+	}
+	server.logger.Printf("Learned %d new addresses!", learned)
+	if learned > 0 {
+		server.addresses.Save()
+	}
+
+	return nil
 }
 
-func HandleBlock(request []byte, blockchain *features.BlockChain) {
-	var buff bytes.Buffer
+func (server *Server) HandleBlock(p *peer, request []byte) error {
 	var payload BlockSender
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewEncoder(&buff)
-	err := dec.Encode(&payload)
+	err := GobDecode(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return fmt.Errorf("%w: %v", errMalformed, err)
 	}
 
-	blockData := payload.Block
-	block := features.DeserializeBlock(blockData)
+	block, err := features.DecodeBlock(payload.Block)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errMalformed, err)
+	}
 
-	fmt.Printf("Received a new Block !!!")
-	blockchain.AddBlock(block)
+	server.logger.Println("Received a new Block !!!")
+	update, err := server.blockchain.AddBlock(block)
+	if err != nil {
+		server.logger.Printf("Rejected block %x: %s", block.GetHash(), err)
+		server.setBlocksInTransit(nil)
 
-	fmt.Printf("Added block %x\n", block.GetHash())
+		if errors.Is(err, features.ErrOrphanBlock) {
+			server.SendGetHeaders(p, server.blockchain.HeaderLocator())
+			return nil
+		}
+		return fmt.Errorf("%w: block %x: %v", errInvalid, block.GetHash(), err)
+	}
 
-	if len(blocksInTransit) > 0 {
-		blockHash := blocksInTransit[0]
-		SendGetData(payload.AddressFrom, "block", blockHash)
+	server.logger.Printf("Added block %x", block.GetHash())
+	server.UpdateMempool(update)
 
-		blocksInTransit = blocksInTransit[1:]
-	} else {
-		UTXOSet := features.UTXOSet{blockchain}
-		UTXOSet.Reindex()
+	if blockHash := server.nextBlockInTransit(); blockHash != nil {
+		server.SendGetData(p, "block", blockHash)
+	} else if len(update.Connected) > 0 {
+		// the downloaded headers may have ended at the message limit, ask for more
+		server.SendGetHeaders(p, server.blockchain.HeaderLocator())
+		server.announce(update.Connected[len(update.Connected)-1].GetHash(), p)
 	}
+
+	return nil
 }
 
-func HandleGetData(request []byte, blockchain *features.BlockChain) {
-	var buff bytes.Buffer
+func (server *Server) HandleGetData(p *peer, request []byte) error {
 	var payload Data
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := GobDecode(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return fmt.Errorf("%w: %v", errMalformed, err)
 	}
 
 	if payload.Type == "block" {
-		block, err := blockchain.GetBlock([]byte(payload.ID))
+		block, err := server.blockchain.GetBlock([]byte(payload.ID))
 		if err != nil {
-			return
+			return nil
 		}
 
-		SendBlock(payload.AddressFrom, &block)
+		server.SendBlock(p, &block)
 	}
 
 	if payload.Type == "TX" {
-		txID := hex.EncodeToString(payload.ID)
-		tx := mempool[txID]
+		tx, ok := server.mempoolTransaction(payload.ID)
+		if !ok {
+			return nil
+		}
 
-		SendTX(payload.AddressFrom, &tx)
+		p.Send(&message{"TX", server.txPayload(&tx)})
 	}
+
+	return nil
 }
 
-func HandleInv(request []byte, blockchain *features.BlockChain) {
-	var buff bytes.Buffer
+func (server *Server) HandleInv(p *peer, request []byte) error {
 	var payload Inv
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := GobDecode(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return fmt.Errorf("%w: %v", errMalformed, err)
 	}
 
-	fmt.Printf("Received inventory with %d %s\n", len(payload.Items), payload.Type)
+	server.logger.Printf("Received inventory with %d %s", len(payload.Items), payload.Type)
 
 	if payload.Type == "block" {
-		blocksInTransit = payload.Items
-
-		blockHash := payload.Items[0]
-		SendGetData(payload.AddressFrom, "block", blockHash)
-
-		newInTransit := [][]byte{}
-		for _, b := range blocksInTransit {
-			if bytes.Compare(b, blockHash) != 0 {
-				newInTransit = append(newInTransit, b)
+		// announced blocks are fetched once their headers connect to ours
+		for _, blockHash := range payload.Items {
+			if !server.blockchain.HasBlock(blockHash) {
+				server.SendGetHeaders(p, server.blockchain.HeaderLocator())
+				break
 			}
 		}
-		blocksInTransit = newInTransit
 	}
 
-	if payload.Type == "TX" {
+	if payload.Type == "TX" && len(payload.Items) > 0 {
 		txID := payload.Items[0]
 
-		if mempool[hex.EncodeToString(txID)].ID == nil {
-			SendGetData(payload.AddressFrom, "TX", txID)
+		if _, ok := server.mempoolTransaction(txID); !ok {
+			server.SendGetData(p, "TX", txID)
 		}
 	}
+
+	return nil
 }
 
-func HandleGetBlocks(request []byte, blockchain *features.BlockChain) {
-	var buff bytes.Buffer
-	var payload Data
+func (server *Server) HandleGetHeaders(p *peer, request []byte) error {
+	var payload GetHeaders
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := GobDecode(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return fmt.Errorf("%w: %v", errMalformed, err)
 	}
 
-	if payload.Type == "block" {
-		block, err := blockchain.GetBlock([]byte(payload.ID))
+	headers := server.blockchain.HeadersAfter(payload.Locator, features.MaxHeadersPerMessage)
+	server.SendHeaders(p, headers)
+
+	return nil
+}
+
+// HandleHeaders stores the headers and downloads the blocks missing for them oldest first,
+// so each block arrives after its parent
+func (server *Server) HandleHeaders(p *peer, request []byte) error {
+	var payload Headers
+
+	err := GobDecode(request, &payload)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errMalformed, err)
+	}
+
+	server.logger.Printf("Received %d headers", len(payload.Headers))
+
+	var missing [][]byte
+	var last []byte
+	for _, data := range payload.Headers {
+		header, err := features.DeserializeBlockHeader(data)
 		if err != nil {
-			return
+			return fmt.Errorf("%w: %v", errMalformed, err)
+		}
+
+		err = server.blockchain.AddHeader(header)
+		if err != nil {
+			server.logger.Printf("Rejected header: %s", err)
+			if errors.Is(err, features.ErrOrphanBlock) {
+				return nil
+			}
+			return fmt.Errorf("%w: header %x: %v", errInvalid, header.GetHash(), err)
 		}
 
-		SendBlock(payload.AddressFrom, &block)
+		if !server.blockchain.HasBlock(header.GetHash()) {
+			missing = append(missing, header.GetHash())
+		}
+		last = header.GetHash()
 	}
 
-	if payload.Type == "TX" {
-		txID := hex.EncodeToString(payload.ID)
-		tx := mempool[txID]
+	if len(missing) == 0 {
+		if len(payload.Headers) == features.MaxHeadersPerMessage {
+			server.SendGetHeaders(p, append([][]byte{last}, server.blockchain.HeaderLocator()...))
+		}
+		return nil
+	}
+
+	server.setBlocksInTransit(missing[1:])
+	server.SendGetData(p, "block", missing[0])
+
+	return nil
+}
+
+func (server *Server) HandleGetProof(p *peer, request []byte) error {
+	var payload GetProof
 
-		SendTX(payload.AddressFrom, &tx)
+	err := GobDecode(request, &payload)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errMalformed, err)
+	}
+
+	proof, err := server.blockchain.GetProof(payload.TXID)
+	if err != nil {
+		server.logger.Printf("No proof for %x: %s", payload.TXID, err)
+		return nil
+	}
+	if proof.Header.IsMigrated() {
+		server.logger.Printf("No proof for %x: migrated blocks cannot be proven to peers", payload.TXID)
+		return nil
 	}
+
+	server.SendProof(p, proof)
+
+	return nil
 }
 
-func HandleTX(request []byte, blockchain *features.BlockChain) {
-	var buff bytes.Buffer
+// HandleProof checks a proof against the headers this node knows. The header in the
+// proof has to be the stored one byte for byte, and migrated headers are refused: their
+// hash is not computed from their fields, so a peer could pair a real one with any root.
+func (server *Server) HandleProof(p *peer, request []byte) error {
+	var payload Proof
+
+	err := GobDecode(request, &payload)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errMalformed, err)
+	}
+
+	proof, err := features.DeserializeMerkleProof(payload.Proof)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errMalformed, err)
+	}
+
+	if proof.Header.IsMigrated() {
+		return fmt.Errorf("%w: proof against migrated block %x", errInvalid, proof.Header.GetHash())
+	}
+
+	err = proof.Verify()
+	if err != nil {
+		return fmt.Errorf("%w: proof: %v", errInvalid, err)
+	}
+
+	header, err := server.blockchain.GetHeader(proof.Header.GetHash())
+	if err != nil {
+		server.logger.Printf("Rejected proof: %s", err)
+		return nil
+	}
+	if !bytes.Equal(header.Serialize(), proof.Header.Serialize()) {
+		return fmt.Errorf("%w: proof header differs from block %x", errInvalid, header.GetHash())
+	}
+
+	server.logger.Printf("Transaction %x is in block %x at height %d", proof.Transaction.ID, proof.Header.GetHash(), proof.Header.GetHeight())
+
+	return nil
+}
+
+// HandleTX adds the transaction to the mempool. The central node relays it to the other
+// nodes, a mining node mines the mempool once it holds at least two transactions.
+func (server *Server) HandleTX(p *peer, request []byte) error {
 	var payload TX
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := GobDecode(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return fmt.Errorf("%w: %v", errMalformed, err)
+	}
+
+	tx, err := features.DecodeTransaction(payload.Transaction)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errMalformed, err)
+	}
+	if _, ok := server.mempoolTransaction(tx.ID); ok {
+		return nil
+	}
+
+	// a transaction spending outputs this node does not have yet may be valid, it is only dropped
+	_, err = server.blockchain.ValidateTransaction(&tx)
+	if errors.Is(err, features.ErrMissingInput) || errors.Is(err, features.ErrImmatureCoinbase) {
+		server.logger.Printf("Dropping transaction %x: %s", tx.ID, err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalid, err)
 	}
 
-	txData := payload.Transaction
-	tx := features.DeserializeTransaction(txData)
-	mempool[hex.EncodeToString(tx.ID)] = tx
+	if !server.addToMempool(tx) {
+		return nil
+	}
 
-	if nodeAddress == knownNodes[0] {
-		for _, node := range knownNodes {
-			if node != nodeAddress && node != payload.AddressFrom {
-				SendInv(node, "TX", [][]byte{tx.ID})
+	if server.isSeed() {
+		for _, node := range server.KnownNodes() {
+			if node == server.address {
+				continue
 			}
-		}
-	} else {
-		if len(mempool) >= 2 && len(miningAddress) > 0 {
-		MineTransactions:
-			var txs []*features.Transaction
-
-			for id := range mempool {
-				tx := mempool[id]
-				if blockchain.VerifyTransaction(&tx) {
-					txs = append(txs, &tx)
-				}
+			if to := server.peerAt(node); to != nil && to != p {
+				server.SendInv(to, "TX", [][]byte{tx.ID})
 			}
+		}
+		return nil
+	}
 
-			if len(txs) == 0 {
-				fmt.Println("All transactions are invalid! Waiting for new ones...")
-				return
-			}
+	if server.mempoolSize() >= 2 {
+		select {
+		case server.wakeMiner <- struct{}{}:
+		default:
+		}
+	}
 
-			cbTx := features.NewCoinbaseTX(miningAddress, "")
-			txs = append(txs, cbTx)
+	return nil
+}
 
-			newBlock := blockchain.MineBlock(txs)
-			UTXOSet := features.UTXOSet{blockchain}
-			UTXOSet.Reindex()
+// miner mines the mempool whenever it is woken up, so that the peer whose transaction
+// filled the mempool does not wait for the block, until the server is closed
+func (server *Server) miner() {
+	defer close(server.minerStopped)
 
-			fmt.Println("New block is mined!!")
+	for {
+		select {
+		case <-server.done:
+			return
+		case <-server.wakeMiner:
+			server.MineTransactions()
+		}
+	}
+}
 
-			for _, tx := range txs {
-				txID := hex.EncodeToString(tx.ID)
-				delete(mempool, txID)
-			}
+// MineTransactions mines blocks from the mempool until it is empty or only holds
+// transactions that cannot be mined yet, and announces them to the known nodes
+func (server *Server) MineTransactions() {
+	server.mining.Lock()
+	defer server.mining.Unlock()
 
-			for _, node := range knownNodes {
-				if node != nodeAddress {
-					SendInv(node, "block", [][]byte{newBlock.GetHash()})
-				}
-			}
+	for server.mempoolSize() > 0 {
+		select {
+		case <-server.done:
+			return
+		default:
+		}
 
-			if len(mempool) > 0 {
-				goto MineTransactions
-			}
+		txs, fees := server.SelectTransactions()
+
+		if len(txs) == 0 {
+			server.logger.Println("All transactions are invalid! Waiting for new ones...")
+			return
+		}
+
+		cbTx := features.NewCoinbaseTX(server.miningAddress, "", server.blockchain.GetBestHeight()+1, fees)
+		// the coinbase goes first, blocks with it anywhere else are invalid
+		txs = append([]*features.Transaction{cbTx}, txs...)
+
+		newBlock, err := server.blockchain.MineBlock(txs)
+		if err != nil {
+			// a block that arrived meanwhile may have spent the same outputs
+			server.logger.Printf("Mining failed: %s", err)
+			return
 		}
+
+		server.logger.Println("New block is mined!!")
+
+		server.removeFromMempool(txs)
+		server.announce(newBlock.GetHash(), nil)
 	}
 }
 
-func HandleVersion(request []byte, blockchain *features.BlockChain) {
-	var buff bytes.Buffer
+// announce relays a new tip to every known node but this one and the peer it came from
+func (server *Server) announce(blockHash []byte, from *peer) {
+	for _, node := range server.KnownNodes() {
+		if node == server.address {
+			continue
+		}
+		if to := server.peerAt(node); to != nil && to != from {
+			server.SendInv(to, "block", [][]byte{blockHash})
+		}
+	}
+}
+
+// HandleVersion starts the synchronization with a peer that finished the handshake. The
+// address an inbound peer claims to listen on is only remembered once it could be dialed.
+func (server *Server) HandleVersion(p *peer, request []byte) error {
 	var payload version
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := GobDecode(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return fmt.Errorf("%w: %v", errMalformed, err)
 	}
 
-	myBestHeight := blockchain.GetBestHeight()
+	myBestHeight := server.blockchain.GetBestHeight()
 	foreignerBestHeight := payload.BestHeight
 
 	if myBestHeight < foreignerBestHeight {
-		SendGetBlock(payload.AddressFrom)
-	} else if myBestHeight > foreignerBestHeight {
-		SendVersion(payload.AddressFrom, blockchain)
+		server.SendGetHeaders(p, server.blockchain.HeaderLocator())
 	}
 
-	if !nodeIsKnown(payload.AddressFrom) {
-		knownNodes = append(knownNodes, payload.AddressFrom)
+	if !p.inbound {
+		server.addresses.Seen(p.address)
+		server.addresses.Save()
+	} else if payload.AddressFrom != "" && payload.AddressFrom != server.address {
+		go server.dialBack(payload.AddressFrom)
 	}
+	server.SendGetAddr(p)
+
+	return nil
 }
 
-func HandleConnection(conn net.Conn, blockchain *features.BlockChain) {
-	request, err := ioutil.ReadAll(conn)
-	if err != nil {
-		log.Panic(err)
+// dialBack connects to the address an inbound peer claims to listen on, which then
+// becomes a known node like any other once the handshake with it is done
+func (server *Server) dialBack(address string) {
+	p := server.peerAt(address)
+	if p == nil {
+		return
 	}
-	command := Bytes2Command(request[:commandLength])
-	fmt.Printf("Received %s command\n", command)
 
-	switch command {
-	case "Address":
-		HandleAddress(request)
+	select {
+	case <-p.handshaken:
+		server.addresses.Seen(address)
+		server.addresses.Save()
+	case <-p.done:
+	case <-server.done:
+	}
+}
+
+// dispatch handles a message from a peer that finished the handshake, replies go back to that peer
+func (server *Server) dispatch(p *peer, msg *message) error {
+	server.logger.Printf("Received %s command", msg.Command)
+	request := msg.Payload
+
+	switch msg.Command {
+	case "getaddr":
+		return server.HandleGetAddr(p, request)
+	case "addr":
+		return server.HandleAddr(p, request)
 	case "block":
-		HandleBlock(request, blockchain)
+		return server.HandleBlock(p, request)
 	case "Inv":
-		HandleInv(request, blockchain)
-	case "BlockSenderAddr":
-		HandleGetBlocks(request, blockchain)
+		return server.HandleInv(p, request)
+	case "getheaders":
+		return server.HandleGetHeaders(p, request)
+	case "headers":
+		return server.HandleHeaders(p, request)
 	case "Data":
-		HandleGetData(request, blockchain)
+		return server.HandleGetData(p, request)
+	case "getproof":
+		return server.HandleGetProof(p, request)
+	case "proof":
+		return server.HandleProof(p, request)
 	case "TX":
-		HandleTX(request, blockchain)
+		return server.HandleTX(p, request)
 	case "version":
-		HandleVersion(request, blockchain)
+		return server.HandleVersion(p, request)
 	default:
-		fmt.Println("Unknown Command!!!")
+		return fmt.Errorf("Unknown Command %q !!!", msg.Command)
 	}
+}
 
-	err = conn.Close()
-	if err != nil {
-		return
+// Serve connects to the most recently seen nodes and gossips addresses with them,
+// then accepts peers until the listener fails
+func (server *Server) Serve(ln net.Listener) error {
+	for _, address := range server.addresses.Select(server.peers.config.MaxOutbound) {
+		if address == server.address {
+			continue
+		}
+
+		_, err := server.peers.connect(address)
+		if err != nil {
+			server.logger.Printf("%s is not available: %s", address, err)
+		}
+	}
+	go server.gossipAddresses()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		server.peers.accept(conn)
 	}
 }
 
 func StartServer(nodeID, minerAddress string) {
-	nodeAddress = fmt.Sprintf("localhost:%s", nodeID)
-	miningAddress = minerAddress
+	nodeAddress := fmt.Sprintf("localhost:%s", nodeID)
 	ln, err := net.Listen(protocol, nodeAddress)
 	if err != nil {
 		log.Panic(err)
@@ -446,32 +770,61 @@ func StartServer(nodeID, minerAddress string) {
 	defer ln.Close()
 
 	blockchain := features.NewBlockChain(nodeID)
+	addresses := NewAddressBook(fmt.Sprintf(addressBookFile, nodeID), PeerSettings.Seeds)
+	server := NewServer(nodeAddress, minerAddress, blockchain, addresses, PeerSettings)
 
-	if nodeAddress != knownNodes[0] {
-		SendVersion(knownNodes[0], blockchain)
+	err = server.Serve(ln)
+	if err != nil {
+		log.Panic(err)
 	}
+}
+
+// gossipAddresses asks a random known node for its addresses and saves the address book
+// every address interval until the server is closed
+func (server *Server) gossipAddresses() {
+	ticker := time.NewTicker(server.peers.addrInterval())
+	defer ticker.Stop()
 
 	for {
-		conn, err := ln.Accept()
-		if err != nil {
-			log.Panic(err)
+		select {
+		case <-server.done:
+			return
+		case <-ticker.C:
 		}
-		go HandleConnection(conn, blockchain)
-	}
-}
 
-func nodeIsKnown(address string) bool {
-	for _, node := range knownNodes {
-		if node == address {
-			return true
+		var nodes []string
+		for _, node := range server.KnownNodes() {
+			if node != server.address {
+				nodes = append(nodes, node)
+			}
+		}
+		if len(nodes) > 0 {
+			if p := server.peerAt(nodes[rand.Intn(len(nodes))]); p != nil {
+				server.SendGetAddr(p)
+			}
 		}
+
+		server.addresses.Save()
 	}
-	return false
 }
 
-func SendGetBlock(address string) {
-	payload := GobEncode(BlockSenderAddr{nodeAddress})
-	request := append(Command2Bytes("BlockSenderAddr"), payload...)
+func (server *Server) setBlocksInTransit(hashes [][]byte) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	server.blocksInTransit = hashes
+}
+
+// nextBlockInTransit takes the next block to download, or nil when there is none
+func (server *Server) nextBlockInTransit() []byte {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if len(server.blocksInTransit) == 0 {
+		return nil
+	}
 
-	SendData(address, request)
+	blockHash := server.blocksInTransit[0]
+	server.blocksInTransit = server.blocksInTransit[1:]
+	return blockHash
 }
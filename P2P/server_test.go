@@ -0,0 +1,34 @@
+package P2P
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestProofsAreCheckedAgainstTheStoredHeader(t *testing.T) {
+	sim := newSimulation(t, 1)
+	server := sim.nodes[0].server
+	genesis := sim.nodes[0].blockchain.Tip
+
+	block, err := sim.nodes[0].blockchain.GetBlock(genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := sim.nodes[0].blockchain.GetProof(block.Transactions[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = server.HandleProof(nil, GobEncode(Proof{"", proof.Serialize()}))
+	if err != nil {
+		t.Fatalf("the proof of the genesis coinbase was rejected: %s", err)
+	}
+
+	// a migrated header keeps the hash it is sent with, so any root would verify against it
+	proof.Header.Version = 0
+	proof.Header.Hash = genesis
+	err = server.HandleProof(nil, GobEncode(Proof{"", proof.Serialize()}))
+	if !errors.Is(err, errInvalid) {
+		t.Fatalf("the proof against a migrated header returned %v", err)
+	}
+}
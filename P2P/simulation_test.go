@@ -0,0 +1,325 @@
+package P2P
+
+import (
+	"COMP5567-BlockChain/features"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const convergenceTimeout = 20 * time.Second
+
+// simulationSettings pings and gossips often so that the nodes notice changes quickly,
+// node 0 is the seed
+var simulationSettings = PeerConfig{
+	MaxInbound:   8,
+	MaxOutbound:  8,
+	BanDuration:  60,
+	PingInterval: 1,
+	AddrInterval: 1,
+	Seeds:        []string{"localhost:3000"},
+}
+
+func TestMain(m *testing.M) {
+	features.InitialDifficulty = 8
+	features.CoinbaseMaturity = 0
+
+	os.Exit(m.Run())
+}
+
+type testNode struct {
+	address    string
+	server     *Server
+	blockchain *features.BlockChain
+	listener   *memoryListener
+}
+
+// simulation runs nodes in this process over a memory network, node 0 being the seed node.
+// Every node starts from the same genesis block paying wallets[0].
+type simulation struct {
+	t       *testing.T
+	network *memoryNetwork
+	nodes   []*testNode
+	wallets []*features.Wallet
+}
+
+// newSimulation starts size nodes, the miners mine blocks paying the wallet with their index
+// once their mempool holds two transactions
+func newSimulation(t *testing.T, size int, miners ...int) *simulation {
+	sim := &simulation{t: t, network: newMemoryNetwork()}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(dir) })
+
+	for i := 0; i < size; i++ {
+		sim.wallets = append(sim.wallets, features.NewWallet())
+	}
+
+	genesis := features.CreateBlockChain(sim.walletAddress(0), nodeID(0))
+	genesis.DB.Close()
+
+	data, err := ioutil.ReadFile(dbFile(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < size; i++ {
+		if i > 0 {
+			err = ioutil.WriteFile(dbFile(i), data, 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		miningAddress := ""
+		for _, miner := range miners {
+			if miner == i {
+				miningAddress = sim.walletAddress(i)
+			}
+		}
+
+		node := &testNode{address: fmt.Sprintf("localhost:%s", nodeID(i))}
+		node.blockchain = features.NewBlockChain(nodeID(i))
+		addresses := NewAddressBook("", simulationSettings.Seeds)
+		node.server = NewServer(node.address, miningAddress, node.blockchain, addresses, simulationSettings)
+		node.server.peers.dial = sim.network.dialer(node.address)
+		node.listener = sim.network.listen(node.address)
+		sim.nodes = append(sim.nodes, node)
+	}
+
+	for _, node := range sim.nodes {
+		go node.server.Serve(node.listener)
+	}
+	t.Cleanup(sim.stop)
+
+	return sim
+}
+
+func nodeID(i int) string {
+	return fmt.Sprintf("%d", 3000+i)
+}
+
+func dbFile(i int) string {
+	return fmt.Sprintf("blockchain_%s.db", nodeID(i))
+}
+
+func (sim *simulation) walletAddress(i int) string {
+	return fmt.Sprintf("%s", sim.wallets[i].GetAddress())
+}
+
+// stop closes the listeners first so that no node can connect anymore, then the servers and their chains
+func (sim *simulation) stop() {
+	for _, node := range sim.nodes {
+		node.listener.Close()
+	}
+	for _, node := range sim.nodes {
+		node.server.Close()
+	}
+	for _, node := range sim.nodes {
+		node.blockchain.DB.Close()
+	}
+}
+
+// mine makes node i mine an empty block paying wallet to and announce it
+func (sim *simulation) mine(i, to int) *features.Block {
+	server := sim.nodes[i].server
+	server.mining.Lock()
+	defer server.mining.Unlock()
+
+	cbTx := features.NewCoinbaseTX(sim.walletAddress(to), "", server.blockchain.GetBestHeight()+1, 0)
+	block, err := server.blockchain.MineBlock([]*features.Transaction{cbTx})
+	if err != nil {
+		sim.t.Fatal(err)
+	}
+	server.announce(block.GetHash(), nil)
+
+	return block
+}
+
+// send makes node i build a transaction from wallet from to wallet to and send it to the seed node
+func (sim *simulation) send(i, from, to, amount, fee int) *features.Transaction {
+	node := sim.nodes[i]
+	UTXOSet := features.UTXOSet{BlockChain: node.blockchain}
+
+	tx := features.NewUTXOTransaction(sim.wallets[from], sim.walletAddress(to), amount, fee, &UTXOSet)
+	node.server.SendTX(sim.nodes[0].address, tx)
+
+	return tx
+}
+
+// tip returns the hash of the last block of the main chain of node i
+func (sim *simulation) tip(i int) []byte {
+	return sim.nodes[i].blockchain.HeaderLocator()[0]
+}
+
+// chainstate returns the serialized UTXO set of node i
+func (sim *simulation) chainstate(i int) map[string][]byte {
+	chainstate := make(map[string][]byte)
+
+	err := sim.nodes[i].blockchain.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(features.UTXOBucket)).ForEach(func(k, v []byte) error {
+			chainstate[string(k)] = append([]byte{}, v...)
+			return nil
+		})
+	})
+	if err != nil {
+		sim.t.Fatal(err)
+	}
+
+	return chainstate
+}
+
+// converged tells whether every node has the tip and UTXO set of node 0
+func (sim *simulation) converged() bool {
+	tip := sim.tip(0)
+	chainstate := sim.chainstate(0)
+
+	for i := 1; i < len(sim.nodes); i++ {
+		if !bytes.Equal(sim.tip(i), tip) {
+			return false
+		}
+
+		other := sim.chainstate(i)
+		if len(other) != len(chainstate) {
+			return false
+		}
+		for k, v := range chainstate {
+			if !bytes.Equal(other[k], v) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// waitFor polls the condition until it holds and fails the test when it does not in time
+func (sim *simulation) waitFor(what string, condition func() bool) {
+	sim.t.Helper()
+
+	deadline := time.Now().Add(convergenceTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			sim.t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func (sim *simulation) waitForConvergence(height int) {
+	sim.t.Helper()
+
+	sim.waitFor(fmt.Sprintf("the nodes to converge at height %d", height), func() bool {
+		return sim.nodes[0].blockchain.GetBestHeight() == height && sim.converged()
+	})
+}
+
+// waitForHandshakes waits until every node finished the handshake with the seed node
+func (sim *simulation) waitForHandshakes() {
+	sim.t.Helper()
+
+	sim.waitFor("the handshakes", func() bool {
+		return len(sim.nodes[0].server.KnownNodes()) == len(sim.nodes)
+	})
+}
+
+func TestBlocksPropagate(t *testing.T) {
+	sim := newSimulation(t, 4)
+	sim.network.setLatency(5 * time.Millisecond)
+	sim.waitForHandshakes()
+
+	sim.mine(2, 2)
+	sim.waitForConvergence(1)
+
+	sim.mine(3, 3)
+	sim.mine(3, 3)
+	sim.waitForConvergence(3)
+
+	if !bytes.Equal(sim.tip(1), sim.tip(3)) {
+		t.Fatalf("node 1 is at %x instead of %x", sim.tip(1), sim.tip(3))
+	}
+}
+
+func TestTransactionsAreMined(t *testing.T) {
+	sim := newSimulation(t, 3, 1)
+	sim.waitForHandshakes()
+
+	sim.mine(0, 2)
+	sim.waitForConvergence(1)
+
+	sim.send(2, 0, 1, 3, 1)
+	sim.send(2, 2, 1, 4, 1)
+	sim.waitForConvergence(2)
+
+	block, err := sim.nodes[2].blockchain.GetBlock(sim.tip(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(block.GetTransactions()) != 3 {
+		t.Fatalf("the mined block has %d transactions instead of 3", len(block.GetTransactions()))
+	}
+
+	for i, node := range sim.nodes {
+		if size := node.server.mempoolSize(); size != 0 {
+			t.Fatalf("node %d still has %d transactions in its mempool", i, size)
+		}
+	}
+}
+
+func TestPartitionHeals(t *testing.T) {
+	sim := newSimulation(t, 3)
+	sim.waitForHandshakes()
+
+	sim.network.partition([]string{sim.nodes[1].address}, []string{sim.nodes[0].address, sim.nodes[2].address})
+
+	sim.mine(2, 2)
+	sim.waitFor("node 0 to get the block of node 2", func() bool {
+		return bytes.Equal(sim.tip(0), sim.tip(2))
+	})
+
+	sim.mine(1, 1)
+	sim.mine(1, 1)
+	if sim.nodes[0].blockchain.GetBestHeight() != 1 {
+		t.Fatal("a block crossed the partition")
+	}
+
+	sim.network.heal()
+	tip := sim.mine(1, 1)
+	sim.waitForConvergence(3)
+
+	if !bytes.Equal(sim.tip(2), tip.GetHash()) {
+		t.Fatalf("node 2 is at %x instead of the heavier branch %x", sim.tip(2), tip.GetHash())
+	}
+}
+
+func TestAddressesGossip(t *testing.T) {
+	sim := newSimulation(t, 4)
+
+	sim.waitFor("every node to learn every address", func() bool {
+		for _, node := range sim.nodes {
+			known := make(map[string]bool)
+			for _, address := range node.server.KnownNodes() {
+				known[address] = true
+			}
+
+			for _, other := range sim.nodes {
+				if other != node && !known[other.address] {
+					return false
+				}
+			}
+		}
+		return true
+	})
+}
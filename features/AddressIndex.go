@@ -0,0 +1,179 @@
+package features
+
+import (
+	"COMP5567-BlockChain/utils"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/boltdb/bolt"
+	"log"
+)
+
+// the address index is only kept when this bucket exists, keys are
+// pubKeyHash || height || txid and values are received || sent, 8 bytes each
+const addressIndexBucket = "addrindex"
+
+var ErrNoAddressIndex = errors.New("address index is not enabled, run reindexUTXO -addrindex")
+
+// AddressTX is a transaction of the main chain that paid an address or spent from it
+type AddressTX struct {
+	TXid     []byte
+	Height   int
+	Received int
+	Sent     int
+}
+
+// EnableAddressIndex creates the address index bucket, a Reindex fills it
+func (blockchain *BlockChain) EnableAddressIndex() {
+	err := blockchain.DB.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(addressIndexBucket))
+		return err
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// HasAddressIndex reports whether the address index is kept
+func (blockchain *BlockChain) HasAddressIndex() bool {
+	enabled := false
+
+	err := blockchain.DB.View(func(tx *bolt.Tx) error {
+		enabled = tx.Bucket([]byte(addressIndexBucket)) != nil
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return enabled
+}
+
+// AddressHistory returns the transactions of the address newest first, skipping offset of
+// them and returning at most limit, along with how many transactions the address has
+func (blockchain *BlockChain) AddressHistory(pubKeyHash []byte, offset, limit int) ([]AddressTX, int, error) {
+	if offset < 0 || limit < 1 {
+		return nil, 0, errors.New("The offset cannot be negative and the limit has to be positive !!!")
+	}
+
+	var history []AddressTX
+	total := 0
+
+	err := blockchain.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(addressIndexBucket))
+		if b == nil {
+			return ErrNoAddressIndex
+		}
+
+		c := b.Cursor()
+		// every key of the address sorts before its hash followed by the largest height
+		end := append(append([]byte{}, pubKeyHash...), bytes.Repeat([]byte{0xff}, 8)...)
+		k, v := c.Seek(end)
+		if k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+
+		for ; k != nil && bytes.HasPrefix(k, pubKeyHash) && len(k) > len(pubKeyHash)+8; k, v = c.Prev() {
+			if total >= offset && len(history) < limit {
+				key := k[len(pubKeyHash):]
+				history = append(history, AddressTX{
+					append([]byte{}, key[8:]...),
+					int(binary.BigEndian.Uint64(key[:8])),
+					int(binary.BigEndian.Uint64(v[:8])),
+					int(binary.BigEndian.Uint64(v[8:])),
+				})
+			}
+			total++
+		}
+		return nil
+	})
+
+	return history, total, err
+}
+
+// indexBlock adds the transactions of a connected block to the address index, undo
+// holds the outputs its inputs spent in order
+func indexBlock(tx *bolt.Tx, block *Block, undo BlockUndo) error {
+	b := tx.Bucket([]byte(addressIndexBucket))
+	if b == nil {
+		return nil
+	}
+
+	spent := undo.Spent
+	for _, transaction := range block.Transactions {
+		amounts := make(map[string][2]int)
+
+		if !transaction.IsCionBase() {
+			for range transaction.TXInputs {
+				if len(spent) == 0 {
+					return errors.New("Undo data does not match the block !!!")
+				}
+				out := spent[0].Output
+				spent = spent[1:]
+
+				amount := amounts[string(out.PubKeyHash)]
+				amount[1] += out.Value
+				amounts[string(out.PubKeyHash)] = amount
+			}
+		}
+
+		for _, out := range transaction.TXOutputs {
+			amount := amounts[string(out.PubKeyHash)]
+			amount[0] += out.Value
+			amounts[string(out.PubKeyHash)] = amount
+		}
+
+		for pubKeyHash, amount := range amounts {
+			if pubKeyHash == "" {
+				continue
+			}
+
+			value := append(utils.Int2Hex(int64(amount[0])), utils.Int2Hex(int64(amount[1]))...)
+			err := b.Put(addressIndexKey([]byte(pubKeyHash), block.Height, transaction.ID), value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// unindexBlock removes the transactions of a disconnected block from the address index
+func unindexBlock(tx *bolt.Tx, block *Block, undo BlockUndo) error {
+	b := tx.Bucket([]byte(addressIndexBucket))
+	if b == nil {
+		return nil
+	}
+
+	spent := undo.Spent
+	for _, transaction := range block.Transactions {
+		var outs []TXOutput
+		if !transaction.IsCionBase() {
+			for range transaction.TXInputs {
+				if len(spent) == 0 {
+					return errors.New("Undo data does not match the block !!!")
+				}
+				outs = append(outs, spent[0].Output)
+				spent = spent[1:]
+			}
+		}
+		outs = append(outs, transaction.TXOutputs...)
+
+		for _, out := range outs {
+			err := b.Delete(addressIndexKey(out.PubKeyHash, block.Height, transaction.ID))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func addressIndexKey(pubKeyHash []byte, height int, txID []byte) []byte {
+	key := append(append([]byte{}, pubKeyHash...), utils.Int2Hex(int64(height))...)
+	return append(key, txID...)
+}
@@ -0,0 +1,95 @@
+package features
+
+import (
+	"testing"
+)
+
+// historyOf returns the IDs of the transactions of the wallet in the address index, newest first
+func historyOf(t *testing.T, blockchain *BlockChain, wallet *Wallet) [][]byte {
+	t.Helper()
+
+	history, total, err := blockchain.AddressHistory(HashPubKey(wallet.PublicKey), 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != len(history) {
+		t.Fatalf("the address has %d transactions but %d were returned", total, len(history))
+	}
+
+	var ids [][]byte
+	for _, entry := range history {
+		ids = append(ids, entry.TXid)
+	}
+	return ids
+}
+
+// sameIDs compares the IDs regardless of their order, transactions of one block are sorted by ID
+func sameIDs(got [][]byte, expected ...[]byte) bool {
+	if len(got) != len(expected) {
+		return false
+	}
+
+	found := make(map[string]bool)
+	for _, id := range got {
+		found[string(id)] = true
+	}
+	for _, id := range expected {
+		if !found[string(id)] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestAddressIndexFollowsReorganizations(t *testing.T) {
+	blockchain, wallet := newTestChain(t)
+	blockchain.EnableAddressIndex()
+	UTXOSet{blockchain}.Reindex()
+
+	genesis := genesisTX(t, blockchain)
+	genesisHash := blockchain.Tip
+	payee := NewWallet()
+	miner := NewWallet()
+
+	// branch a pays the payee from the genesis coinbase, branch b overtakes it
+	payment := spend(t, blockchain, wallet, genesis, 0, *NewTXOutput(10, walletAddress(payee)))
+	a1 := addBlock(t, blockchain, genesisHash, payee, payment)
+	if ids := historyOf(t, blockchain, payee); !sameIDs(ids, a1.Transactions[0].ID, payment.ID) {
+		t.Fatalf("the payee has %x before the reorganization", ids)
+	}
+
+	b1 := addBlock(t, blockchain, genesisHash, miner)
+	b2 := addBlock(t, blockchain, b1.Hash, miner)
+
+	if ids := historyOf(t, blockchain, payee); len(ids) != 0 {
+		t.Fatalf("the payee kept %x from the disconnected block", ids)
+	}
+	if ids := historyOf(t, blockchain, wallet); !sameIDs(ids, genesis.ID) {
+		t.Fatalf("the sender has %x after the reorganization", ids)
+	}
+	if ids := historyOf(t, blockchain, miner); !sameIDs(ids, b2.Transactions[0].ID, b1.Transactions[0].ID) {
+		t.Fatalf("the miner has %x after the reorganization", ids)
+	}
+
+	// branch a takes over again and brings the payment back
+	a2 := addBlock(t, blockchain, a1.Hash, payee)
+	addBlock(t, blockchain, a2.Hash, wallet)
+
+	if ids := historyOf(t, blockchain, payee); !sameIDs(ids, a2.Transactions[0].ID, a1.Transactions[0].ID, payment.ID) {
+		t.Fatalf("the payee has %x after reorganizing back", ids)
+	}
+	if ids := historyOf(t, blockchain, miner); len(ids) != 0 {
+		t.Fatalf("the miner kept %x from the disconnected blocks", ids)
+	}
+}
+
+func TestAddressHistoryRejectsBadPages(t *testing.T) {
+	blockchain, wallet := newTestChain(t)
+	blockchain.EnableAddressIndex()
+
+	for _, page := range [][2]int{{-1, 10}, {0, 0}, {0, -1}} {
+		if _, _, err := blockchain.AddressHistory(HashPubKey(wallet.PublicKey), page[0], page[1]); err == nil {
+			t.Fatalf("offset %d and limit %d were accepted", page[0], page[1])
+		}
+	}
+}
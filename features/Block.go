@@ -1,25 +1,19 @@
 package features
 
 import (
-	"bytes"
-	"encoding/gob"
-	"fmt"
 	"log"
 	"time"
 )
 
 type Block struct {
-	TimeStamp    int64
+	BlockHeader
 	Transactions []*Transaction
-	PreviousHash []byte
-	Hash         []byte
-	Nonce        int
-	Height       int
 }
 
-func NewBlock(transactions []*Transaction, previousHash []byte, height int) *Block {
-	block := &Block{time.Now().Unix(), transactions, previousHash, []byte{}, 0, height}
-	pow := NewPOW(block)
+func NewBlock(transactions []*Transaction, previousHash []byte, height, difficulty int) *Block {
+	block := &Block{Transactions: transactions}
+	block.BlockHeader = BlockHeader{BlockVersion, previousHash, block.HashTransactions(), time.Now().Unix(), height, difficulty, 0, []byte{}}
+	pow := NewPOW(&block.BlockHeader)
 	nonce, hash := pow.Run()
 
 	block.Hash = hash[:]
@@ -28,7 +22,7 @@ func NewBlock(transactions []*Transaction, previousHash []byte, height int) *Blo
 }
 
 func NewGenesisBlock(coinbase *Transaction) *Block {
-	return NewBlock([]*Transaction{coinbase}, []byte{}, 0)
+	return NewBlock([]*Transaction{coinbase}, []byte{}, 0, InitialDifficulty)
 }
 
 func (block *Block) HashTransactions() []byte {
@@ -43,41 +37,26 @@ func (block *Block) HashTransactions() []byte {
 	return mTree.RootNode.Value
 }
 
+// Serialize returns the canonical encoding of the block, see Encoding.go
 func (block *Block) Serialize() []byte {
-	var result bytes.Buffer
-	encoder := gob.NewEncoder(&result)
+	var e encoder
+	block.encode(&e)
 
-	err := encoder.Encode(&block)
-	if err != nil {
-		fmt.Println("Decryption ERROR: ", err)
-		return nil
-	}
-
-	return result.Bytes()
+	return e.Bytes()
 }
 
 func DeserializeBlock(input []byte) *Block {
-	var block Block
-
-	decode := gob.NewDecoder(bytes.NewReader(input))
-	err := decode.Decode(&block)
+	block, err := decodeBlock(input)
 	if err != nil {
 		log.Println("Decryption ERROR: ", err)
 	}
 
-	return &block
-}
-
-func (block *Block) GetHash() []byte {
-	return block.Hash
-}
-
-func (block *Block) GetHeight() int {
-	return block.Height
+	return block
 }
 
-func (block *Block) GetPreviousHash() []byte {
-	return block.PreviousHash
+// DecodeBlock is DeserializeBlock for data from untrusted sources, it returns the decoding error
+func DecodeBlock(input []byte) (*Block, error) {
+	return decodeBlock(input)
 }
 
 func (block *Block) GetTransactions() []*Transaction {
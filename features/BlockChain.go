@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/boltdb/bolt"
+	"io"
 	"log"
 	"os"
 )
@@ -32,7 +33,7 @@ func CreateBlockChain(address, nodeID string) *BlockChain {
 	}
 
 	var tip []byte
-	cbtx := NewCoinbaseTX(address, genesisCoinbaseData)
+	cbtx := NewCoinbaseTX(address, genesisCoinbaseData, 0, 0)
 	genesis := NewGenesisBlock(cbtx)
 
 	db, err := bolt.Open(dbFile, 0600, nil)
@@ -46,7 +47,7 @@ func CreateBlockChain(address, nodeID string) *BlockChain {
 			log.Panic(err)
 		}
 
-		err = b.Put(genesis.GetHash(), genesis.Serialize())
+		err = storeBlock(transaction, genesis)
 		if err != nil {
 			log.Panic(err)
 		}
@@ -56,6 +57,31 @@ func CreateBlockChain(address, nodeID string) *BlockChain {
 			log.Panic(err)
 		}
 
+		_, err = transaction.CreateBucket([]byte(UTXOBucket))
+		if err != nil {
+			log.Panic(err)
+		}
+
+		err = connectBlock(transaction, genesis)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		err = setDBVersion(transaction, latestDBVersion())
+		if err != nil {
+			log.Panic(err)
+		}
+
+		err = putEmission(transaction, Emission)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		err = putCoinbaseMaturity(transaction, CoinbaseMaturity)
+		if err != nil {
+			log.Panic(err)
+		}
+
 		tip = genesis.GetHash()
 
 		return nil
@@ -84,7 +110,7 @@ func NewBlockChain(nodeID string) *BlockChain {
 
 	err = db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
-		tip = b.Get([]byte("l"))
+		tip = append([]byte{}, b.Get([]byte("l"))...)
 
 		return nil
 	})
@@ -92,11 +118,38 @@ func NewBlockChain(nodeID string) *BlockChain {
 		log.Fatal(err)
 	}
 
+	err = migrate(db)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = db.View(func(tx *bolt.Tx) error {
+		err := loadEmission(tx)
+		if err != nil {
+			return err
+		}
+		return loadCoinbaseMaturity(tx)
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
 	bc := BlockChain{tip, db}
 	return &bc
 }
 
-func (blockchain *BlockChain) AddBlock(block *Block) {
+// ChainUpdate lists the blocks that left and joined the main chain when a block was added
+type ChainUpdate struct {
+	Disconnected []*Block
+	Connected    []*Block
+}
+
+// AddBlock validates and stores the block and, when its branch carries more accumulated
+// work than the current tip, reorganizes the main chain and the UTXO set onto it.
+// Blocks breaking a consensus rule are rejected with one of the Err* validation errors.
+func (blockchain *BlockChain) AddBlock(block *Block) (*ChainUpdate, error) {
+	update := &ChainUpdate{}
+
 	err := blockchain.DB.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 		blockInDB := b.Get(block.GetHash())
@@ -105,49 +158,126 @@ func (blockchain *BlockChain) AddBlock(block *Block) {
 			return nil
 		}
 
-		blockData := block.Serialize()
-		err := b.Put(block.GetHash(), blockData)
+		err := checkBlock(tx, block)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 
-		lastHash := b.Get([]byte("l"))
-		lastBlockData := b.Get(lastHash)
-		lastBlock := DeserializeBlock(lastBlockData)
+		err = storeBlock(tx, block)
+		if err != nil {
+			return err
+		}
 
-		if block.GetHeight() > lastBlock.GetHeight() {
-			err = b.Put([]byte("l"), block.GetHash())
-			if err != nil {
-				log.Fatal(err)
-			}
-			blockchain.Tip = block.GetHash()
+		blockWork, err := chainWork(tx, &block.BlockHeader)
+		if err != nil {
+			return err
 		}
-		return nil
+
+		lastBlock := DeserializeBlock(b.Get(b.Get([]byte("l"))))
+		lastWork, err := chainWork(tx, &lastBlock.BlockHeader)
+		if err != nil {
+			return err
+		}
+
+		if blockWork.Cmp(lastWork) <= 0 {
+			return nil
+		}
+
+		update, err = reorganize(tx, lastBlock, block)
+		return err
 	})
 
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+
+	if len(update.Connected) > 0 {
+		blockchain.Tip = block.GetHash()
 	}
+	return update, nil
 }
 
-func (blockchain *BlockChain) FindTransaction(Id []byte) (Transaction, error) {
-	iterator := blockchain.Iterator()
+// reorganize moves the main chain from oldTip to newTip, disconnecting the old
+// branch down to the fork point and connecting the new one in the same transaction
+func reorganize(tx *bolt.Tx, oldTip, newTip *Block) (*ChainUpdate, error) {
+	b := tx.Bucket([]byte(blocksBucket))
+	update := &ChainUpdate{}
+	oldBlock, newBlock := oldTip, newTip
+
+	for !bytes.Equal(oldBlock.GetHash(), newBlock.GetHash()) {
+		if newBlock.GetHeight() >= oldBlock.GetHeight() {
+			update.Connected = append([]*Block{newBlock}, update.Connected...)
+			newBlock = DeserializeBlock(b.Get(newBlock.GetPreviousHash()))
+		} else {
+			update.Disconnected = append(update.Disconnected, oldBlock)
+			oldBlock = DeserializeBlock(b.Get(oldBlock.GetPreviousHash()))
+		}
+	}
 
-	for {
-		block := iterator.Next()
+	for _, block := range update.Disconnected {
+		err := disconnectBlock(tx, block)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-		for _, tx := range block.Transactions {
-			if bytes.Compare(tx.ID, Id) == 0 {
-				return *tx, nil
-			}
+	for _, block := range update.Connected {
+		err := checkTransactions(tx, block)
+		if err != nil {
+			return nil, err
 		}
 
-		if len(block.PreviousHash) == 0 {
-			break
+		err = connectBlock(tx, block)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := b.Put([]byte("l"), newTip.GetHash())
+	if err != nil {
+		return nil, err
+	}
+
+	return update, nil
+}
+
+// DisconnectTip rolls the main chain back by one block with its undo record, moving the
+// tip to the parent in the same transaction. The block stays stored on a side branch.
+func (blockchain *BlockChain) DisconnectTip() (*Block, error) {
+	var block *Block
+
+	err := blockchain.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		block = DeserializeBlock(b.Get(b.Get([]byte("l"))))
+		if block.GetHeight() == 0 {
+			return errors.New("The genesis block cannot be disconnected !!!")
+		}
+
+		err := disconnectBlock(tx, block)
+		if err != nil {
+			return err
 		}
+
+		return b.Put([]byte("l"), block.GetPreviousHash())
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return Transaction{}, errors.New("Transaction is not found !!!")
+	blockchain.Tip = block.GetPreviousHash()
+	return block, nil
+}
+
+func (blockchain *BlockChain) FindTransaction(Id []byte) (Transaction, error) {
+	var transaction Transaction
+
+	err := blockchain.DB.View(func(tx *bolt.Tx) error {
+		var err error
+		transaction, err = lookupTransaction(tx, Id)
+		return err
+	})
+
+	return transaction, err
 }
 
 func (blockchain *BlockChain) FindUTXO() map[string]TXOutputs {
@@ -156,7 +286,14 @@ func (blockchain *BlockChain) FindUTXO() map[string]TXOutputs {
 	iter := blockchain.Iterator()
 
 	for {
-		block := iter.Next()
+		block, err := iter.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Panic(err)
+		}
+
 		for _, tx := range block.Transactions {
 			txID := hex.EncodeToString(tx.ID)
 
@@ -171,7 +308,10 @@ func (blockchain *BlockChain) FindUTXO() map[string]TXOutputs {
 				}
 
 				outs := UTXO[txID]
-				outs.Outputs = append(outs.Outputs, out)
+				if outs.Outputs == nil {
+					outs = TXOutputs{make(map[int]TXOutput), block.Height, tx.IsCionBase()}
+				}
+				outs.Outputs[outIdx] = out
 				UTXO[txID] = outs
 			}
 
@@ -182,17 +322,13 @@ func (blockchain *BlockChain) FindUTXO() map[string]TXOutputs {
 				}
 			}
 		}
-		if len(block.PreviousHash) == 0 {
-			break
-		}
 	}
 	return UTXO
 }
 
+// Iterator walks backwards from the tip to genesis
 func (blockchain *BlockChain) Iterator() *BlockChainIterator {
-	iterator := &BlockChainIterator{blockchain.Tip, blockchain.DB}
-
-	return iterator
+	return blockchain.IteratorFrom(blockchain.Tip)
 }
 
 func (blockchain *BlockChain) GetBestHeight() int {
@@ -241,95 +377,95 @@ func (bc *BlockChain) GetBlockHashes() [][]byte {
 	iter := bc.Iterator()
 
 	for {
-		block := iter.Next()
-
-		blocks = append(blocks, block.Hash)
-
-		if len(block.PreviousHash) == 0 {
+		block, err := iter.Next()
+		if err == io.EOF {
 			break
 		}
+		if err != nil {
+			log.Panic(err)
+		}
+
+		blocks = append(blocks, block.Hash)
 	}
 	return blocks
 }
 
-func (bc *BlockChain) MineBlock(transactions []*Transaction) *Block {
+// MineBlock mines a block with the transactions on top of the tip and adds it to the chain
+func (bc *BlockChain) MineBlock(transactions []*Transaction) (*Block, error) {
 	var lastHash []byte
 	var lastHeight int
+	var difficulty int
 
 	for _, tx := range transactions {
-		if bc.VerifyTransaction(tx) != true {
-			log.Panic("ERROR: Invalid transaction")
+		err := bc.VerifyTransaction(tx)
+		if err != nil {
+			return nil, err
 		}
 	}
 
 	err := bc.DB.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
-		lastHash = b.Get([]byte("l"))
+		lastHash = append([]byte{}, b.Get([]byte("l"))...)
 
 		blockData := b.Get(lastHash)
 		block := DeserializeBlock(blockData)
 		lastHeight = block.Height
+		difficulty = nextDifficulty(tx.Bucket([]byte(headersBucket)), &block.BlockHeader)
 		return nil
 	})
-
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	nBlock := NewBlock(transactions, lastHash, lastHeight+1)
+	nBlock := NewBlock(transactions, lastHash, lastHeight+1, difficulty)
 
-	err = bc.DB.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		err := b.Put(nBlock.Hash, nBlock.Serialize())
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		err = b.Put([]byte("l"), nBlock.Hash)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		bc.Tip = nBlock.Hash
-		return nil
-	})
+	_, err = bc.AddBlock(nBlock)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	return nBlock
+	return nBlock, nil
 }
 
-func (bc *BlockChain) SignTransaction(tx *Transaction, privateKey ecdsa.PrivateKey) {
-	prevTXs := make(map[string]Transaction)
-
-	for _, in := range tx.TXInputs {
-		prevTX, err := bc.FindTransaction(in.TXid)
-		if err != nil {
-			log.Fatal(err)
-		}
-		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
+func (bc *BlockChain) SignTransaction(tx *Transaction, privateKey ecdsa.PrivateKey) error {
+	prevTXs, err := bc.previousTransactions(tx)
+	if err != nil {
+		return err
 	}
 
 	tx.Sign(privateKey, prevTXs)
+	return nil
 }
 
-func (bc *BlockChain) VerifyTransaction(tx *Transaction) bool {
+func (bc *BlockChain) VerifyTransaction(tx *Transaction) error {
 	if tx.IsCionBase() {
-		return true
+		return nil
+	}
+
+	prevTXs, err := bc.previousTransactions(tx)
+	if err != nil {
+		return err
 	}
 
+	if !tx.Verify(prevTXs, bc.GetBestHeight()+1) {
+		return fmt.Errorf("%w: %x", ErrBadSignature, tx.ID)
+	}
+	return nil
+}
+
+// previousTransactions finds the transactions holding the outputs the inputs of tx spend
+func (bc *BlockChain) previousTransactions(tx *Transaction) (map[string]Transaction, error) {
 	prevTXs := make(map[string]Transaction)
 
 	for _, in := range tx.TXInputs {
 		prevTX, err := bc.FindTransaction(in.TXid)
-		if err != nil {
-			log.Fatal(err)
+		if err != nil || in.Value < 0 || in.Value >= len(prevTX.TXOutputs) {
+			return nil, fmt.Errorf("%w: %x:%d", ErrMissingInput, in.TXid, in.Value)
 		}
 		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
 	}
 
-	return tx.Verify(prevTXs)
+	return prevTXs, nil
 }
 
 func dbExists(dbFile string) bool {
@@ -1,29 +1,109 @@
 package features
 
 import (
+	"fmt"
 	"github.com/boltdb/bolt"
-	"log"
+	"io"
 )
 
+// BlockChainIterator walks blocks backwards by following PreviousHash from CurrentHash,
+// or, when it is forward, up the height index of the main chain.
+// Next returns io.EOF once it passes the bound or either end of the chain.
 type BlockChainIterator struct {
 	CurrentHash []byte
 	DB          *bolt.DB
+
+	forward    bool
+	nextHeight int
+	// backward iteration ends below stopHeight, forward iteration above it when bounded
+	stopHeight int
+	bounded    bool
 }
 
-func (iter *BlockChainIterator) Next() *Block {
-	var block *Block
+// IteratorFrom walks backwards from the block with the hash, which may be on a side branch
+func (blockchain *BlockChain) IteratorFrom(hash []byte) *BlockChainIterator {
+	return &BlockChainIterator{CurrentHash: hash, DB: blockchain.DB}
+}
+
+// ForwardIterator walks the main chain from the height towards the tip
+func (blockchain *BlockChain) ForwardIterator(height int) *BlockChainIterator {
+	return &BlockChainIterator{DB: blockchain.DB, forward: true, nextHeight: height}
+}
+
+// RangeIterator walks the main chain from one height to another, both included,
+// forwards when from is below to and backwards otherwise
+func (blockchain *BlockChain) RangeIterator(from, to int) (*BlockChainIterator, error) {
+	if from <= to {
+		return blockchain.ForwardIterator(from).Until(to), nil
+	}
+
+	block, err := blockchain.GetBlockByHeight(from)
+	if err != nil {
+		return nil, err
+	}
+
+	return blockchain.IteratorFrom(block.Hash).Until(to), nil
+}
+
+// Until bounds the iteration, it ends after the block at the height
+func (iter *BlockChainIterator) Until(height int) *BlockChainIterator {
+	iter.stopHeight = height
+	iter.bounded = true
+
+	return iter
+}
+
+// Next returns the next block, or io.EOF when the iteration is over
+func (iter *BlockChainIterator) Next() (*Block, error) {
+	if iter.forward {
+		return iter.nextForward()
+	}
 
+	if len(iter.CurrentHash) == 0 {
+		return nil, io.EOF
+	}
+
+	var block *Block
 	err := iter.DB.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("blocks"))
-		encodedBlock := b.Get(iter.CurrentHash)
+		encodedBlock := tx.Bucket([]byte(blocksBucket)).Get(iter.CurrentHash)
+		if encodedBlock == nil {
+			return fmt.Errorf("Block %x is not found !!!", iter.CurrentHash)
+		}
 		block = DeserializeBlock(encodedBlock)
 		return nil
 	})
-
 	if err != nil {
-		log.Panic(err)
+		return nil, err
+	}
+
+	if iter.bounded && block.Height < iter.stopHeight {
+		iter.CurrentHash = nil
+		return nil, io.EOF
 	}
 
 	iter.CurrentHash = block.PreviousHash
-	return block
+	return block, nil
+}
+
+func (iter *BlockChainIterator) nextForward() (*Block, error) {
+	if iter.bounded && iter.nextHeight > iter.stopHeight {
+		return nil, io.EOF
+	}
+
+	var block *Block
+	err := iter.DB.View(func(tx *bolt.Tx) error {
+		hash := tx.Bucket([]byte(heightIndexBucket)).Get(heightKey(iter.nextHeight))
+		if hash == nil {
+			return io.EOF
+		}
+		block = DeserializeBlock(tx.Bucket([]byte(blocksBucket)).Get(hash))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	iter.CurrentHash = block.Hash
+	iter.nextHeight++
+	return block, nil
 }
@@ -0,0 +1,266 @@
+package features
+
+import (
+	"fmt"
+	"github.com/boltdb/bolt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	InitialDifficulty = minTargetBits
+	CoinbaseMaturity = 0
+
+	os.Exit(m.Run())
+}
+
+// newTestChain creates a chain in a temporary directory whose genesis coinbase pays a new wallet
+func newTestChain(t *testing.T) (*BlockChain, *Wallet) {
+	t.Helper()
+
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(dir) })
+
+	wallet := NewWallet()
+	blockchain := CreateBlockChain(walletAddress(wallet), "test")
+	t.Cleanup(func() { blockchain.DB.Close() })
+
+	return blockchain, wallet
+}
+
+// openFixture opens a copy of the database the baseline wrote, blocks in gob with no
+// difficulty, in a temporary directory, which migrates it to the latest version. The
+// fixture is frozen in testdata since the CLI migrates blockchain_0.db in place.
+// prepare gets the copy before it is opened, to bring it to an intermediate version.
+// Opening it loads the stored chain parameters, which are reset once the test ends.
+func openFixture(t *testing.T, prepare func(db *bolt.DB)) *BlockChain {
+	t.Helper()
+
+	emission, maturity := Emission, CoinbaseMaturity
+	t.Cleanup(func() { Emission, CoinbaseMaturity = emission, maturity })
+
+	data, err := ioutil.ReadFile("testdata/baseline_blockchain.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(dir) })
+
+	err = ioutil.WriteFile("blockchain_0.db", data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if prepare != nil {
+		db, err := bolt.Open("blockchain_0.db", 0600, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prepare(db)
+		db.Close()
+	}
+
+	blockchain := NewBlockChain("0")
+	t.Cleanup(func() { blockchain.DB.Close() })
+
+	return blockchain
+}
+
+func walletAddress(wallet *Wallet) string {
+	return fmt.Sprintf("%s", wallet.GetAddress())
+}
+
+// buildBlock mines a block with the transactions on top of parent without adding it
+func buildBlock(t *testing.T, blockchain *BlockChain, parent []byte, transactions ...*Transaction) *Block {
+	t.Helper()
+
+	previous, err := blockchain.GetBlock(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewBlock(transactions, previous.GetHash(), previous.GetHeight()+1, blockchain.NextDifficulty(&previous))
+}
+
+// addBlock mines a block paying the wallet on top of parent with the transactions and adds it
+func addBlock(t *testing.T, blockchain *BlockChain, parent []byte, wallet *Wallet, transactions ...*Transaction) *Block {
+	t.Helper()
+
+	previous, err := blockchain.GetBlock(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbase := NewCoinbaseTX(walletAddress(wallet), "", previous.GetHeight()+1, 0)
+
+	block := buildBlock(t, blockchain, parent, append([]*Transaction{coinbase}, transactions...)...)
+	_, err = blockchain.AddBlock(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return block
+}
+
+// genesisTX returns the coinbase of the genesis block
+func genesisTX(t *testing.T, blockchain *BlockChain) *Transaction {
+	t.Helper()
+
+	block, err := blockchain.ForwardIterator(0).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return block.Transactions[0]
+}
+
+// spend builds a transaction spending output index of previous with the outputs, signed with the wallet's key
+func spend(t *testing.T, blockchain *BlockChain, wallet *Wallet, previous *Transaction, index int, outputs ...TXOutput) *Transaction {
+	t.Helper()
+
+	input := TXInput{previous.ID, index, nil, wallet.PublicKey, nil}
+	transaction := Transaction{nil, []TXInput{input}, outputs, TransactionVersion}
+	transaction.ID = transaction.Hash()
+	err := blockchain.SignTransaction(&transaction, *wallet.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &transaction
+}
+
+// snapshot copies the chainstate, the undo records and the indexes
+func snapshot(t *testing.T, blockchain *BlockChain) map[string]map[string]string {
+	t.Helper()
+
+	buckets := make(map[string]map[string]string)
+	err := blockchain.DB.View(func(tx *bolt.Tx) error {
+		for _, bucketName := range []string{UTXOBucket, undoBucket, txIndexBucket, heightIndexBucket} {
+			entries := make(map[string]string)
+			err := tx.Bucket([]byte(bucketName)).ForEach(func(k, v []byte) error {
+				entries[string(k)] = string(v)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			buckets[bucketName] = entries
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buckets
+}
+
+func TestReorganizationsRestoreTheUTXOSet(t *testing.T) {
+	blockchain, wallet := newTestChain(t)
+	UTXOSet{blockchain}.Reindex()
+
+	payee := NewWallet()
+	miner := NewWallet()
+
+	blocks := map[string][]byte{"genesis": blockchain.Tip}
+	transactions := map[string]*Transaction{"genesis": genesisTX(t, blockchain)}
+
+	// branch a spends the genesis coinbase and then the payment, branch b overtakes it and a takes over again
+	tests := []struct {
+		name     string
+		parent   string
+		spend    func() *Transaction
+		tip      string
+		unspent  []string
+		consumed []string
+	}{
+		{"a1", "genesis", func() *Transaction {
+			transactions["payment"] = spend(t, blockchain, wallet, transactions["genesis"], 0, *NewTXOutput(10, walletAddress(payee)))
+			return transactions["payment"]
+		}, "a1", []string{"payment"}, []string{"genesis"}},
+		{"a2", "a1", func() *Transaction {
+			transactions["respend"] = spend(t, blockchain, payee, transactions["payment"], 0, *NewTXOutput(10, walletAddress(miner)))
+			return transactions["respend"]
+		}, "a2", []string{"respend"}, []string{"genesis", "payment"}},
+		{"b1", "genesis", nil, "a2", []string{"respend"}, []string{"genesis", "payment"}},
+		{"b2", "b1", nil, "a2", []string{"respend"}, []string{"genesis", "payment"}},
+		{"b3", "b2", nil, "b3", []string{"genesis"}, []string{"payment", "respend"}},
+		{"a3", "a2", nil, "b3", []string{"genesis"}, []string{"payment", "respend"}},
+		{"a4", "a3", nil, "a4", []string{"respend"}, []string{"genesis", "payment"}},
+	}
+
+	for _, test := range tests {
+		var included []*Transaction
+		if test.spend != nil {
+			included = append(included, test.spend())
+		}
+		blocks[test.name] = addBlock(t, blockchain, blocks[test.parent], miner, included...).Hash
+
+		if string(blockchain.Tip) != string(blocks[test.tip]) {
+			t.Fatalf("after %s the tip is %x instead of %s", test.name, blockchain.Tip, test.tip)
+		}
+
+		state := snapshot(t, blockchain)
+		for _, name := range test.unspent {
+			if _, ok := state[UTXOBucket][string(transactions[name].ID)]; !ok {
+				t.Fatalf("after %s the outputs of %s are missing", test.name, name)
+			}
+		}
+		for _, name := range test.consumed {
+			if _, ok := state[UTXOBucket][string(transactions[name].ID)]; ok {
+				t.Fatalf("after %s the outputs of %s are unspent", test.name, name)
+			}
+		}
+
+		UTXOSet{blockchain}.Reindex()
+		if !reflect.DeepEqual(state, snapshot(t, blockchain)) {
+			t.Fatalf("after %s the chainstate differs from the reindexed one", test.name)
+		}
+	}
+}
+
+func TestDisconnectTipMovesTheTip(t *testing.T) {
+	blockchain, wallet := newTestChain(t)
+	UTXOSet{blockchain}.Reindex()
+
+	genesis := genesisTX(t, blockchain)
+	genesisHash := blockchain.Tip
+	before := snapshot(t, blockchain)
+
+	payment := spend(t, blockchain, wallet, genesis, 0, *NewTXOutput(10, walletAddress(NewWallet())))
+	block := addBlock(t, blockchain, genesisHash, wallet, payment)
+
+	disconnected, err := blockchain.DisconnectTip()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(disconnected.Hash) != string(block.Hash) || string(blockchain.Tip) != string(genesisHash) {
+		t.Fatalf("disconnected %x and moved the tip to %x", disconnected.Hash, blockchain.Tip)
+	}
+	if height := blockchain.GetBestHeight(); height != 0 {
+		t.Fatalf("the stored tip is at height %d", height)
+	}
+	if !reflect.DeepEqual(before, snapshot(t, blockchain)) {
+		t.Fatal("the chainstate differs from the one before the block")
+	}
+
+	if _, err := blockchain.DisconnectTip(); err == nil {
+		t.Fatal("the genesis block was disconnected")
+	}
+}
@@ -0,0 +1,220 @@
+package features
+
+import (
+	"bytes"
+	"errors"
+	"github.com/boltdb/bolt"
+	"log"
+)
+
+// maps the hash of every known block header to the header, including headers
+// of blocks that were not downloaded yet
+const headersBucket = "headers"
+
+// the most headers returned for one locator
+const MaxHeadersPerMessage = 2000
+
+// BlockHeader holds the fields the proof of work commits to, the transactions
+// only enter it through their Merkle root
+type BlockHeader struct {
+	Version      int
+	PreviousHash []byte
+	MerkleRoot   []byte
+	TimeStamp    int64
+	Height       int
+	Difficulty   int
+	Nonce        int
+	Hash         []byte
+}
+
+// Serialize returns the fixed-size encoding of the header, see Encoding.go
+func (header *BlockHeader) Serialize() []byte {
+	var e encoder
+	header.encode(&e)
+
+	return e.Bytes()
+}
+
+func DeserializeBlockHeader(data []byte) (*BlockHeader, error) {
+	return decodeBlockHeader(newDecoder(data), true)
+}
+
+func (header *BlockHeader) GetHash() []byte {
+	return header.Hash
+}
+
+func (header *BlockHeader) GetHeight() int {
+	return header.Height
+}
+
+func (header *BlockHeader) GetDifficulty() int {
+	return header.Difficulty
+}
+
+func (header *BlockHeader) GetPreviousHash() []byte {
+	return header.PreviousHash
+}
+
+// IsMigrated reports whether the header comes from a gob block, whose hash was stored
+// rather than computed from the header fields
+func (header *BlockHeader) IsMigrated() bool {
+	return header.Version == legacyVersion
+}
+
+// AddHeader validates and stores a header received ahead of its block
+func (blockchain *BlockChain) AddHeader(header *BlockHeader) error {
+	return blockchain.DB.Update(func(tx *bolt.Tx) error {
+		headers := tx.Bucket([]byte(headersBucket))
+		if headers.Get(header.Hash) != nil {
+			return nil
+		}
+
+		err := checkHeader(tx, header)
+		if err != nil {
+			return err
+		}
+
+		return headers.Put(header.Hash, header.Serialize())
+	})
+}
+
+// GetHeader returns the header with the hash, whether or not its block is stored
+func (blockchain *BlockChain) GetHeader(hash []byte) (BlockHeader, error) {
+	var header BlockHeader
+
+	err := blockchain.DB.View(func(tx *bolt.Tx) error {
+		found, err := getHeader(tx.Bucket([]byte(headersBucket)), hash)
+		if err != nil {
+			return err
+		}
+
+		header = *found
+		return nil
+	})
+
+	return header, err
+}
+
+// HasBlock tells whether the full block with the hash is stored
+func (blockchain *BlockChain) HasBlock(hash []byte) bool {
+	found := false
+
+	err := blockchain.DB.View(func(tx *bolt.Tx) error {
+		found = tx.Bucket([]byte(blocksBucket)).Get(hash) != nil
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return found
+}
+
+// HeaderLocator lists main chain hashes from the tip back to genesis, one by one for the
+// last ten blocks and then doubling the step, so a peer can find where its chain forks
+func (blockchain *BlockChain) HeaderLocator() [][]byte {
+	var locator [][]byte
+
+	err := blockchain.DB.View(func(tx *bolt.Tx) error {
+		index := tx.Bucket([]byte(heightIndexBucket))
+		b := tx.Bucket([]byte(blocksBucket))
+		tip := DeserializeBlock(b.Get(b.Get([]byte("l"))))
+
+		step := 1
+		for height := tip.Height; height > 0; height -= step {
+			locator = append(locator, append([]byte{}, index.Get(heightKey(height))...))
+			if len(locator) >= 10 {
+				step *= 2
+			}
+		}
+		locator = append(locator, append([]byte{}, index.Get(heightKey(0))...))
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return locator
+}
+
+// HeadersAfter returns up to limit main chain headers following the first locator hash
+// on the main chain, starting from genesis when none of them is
+func (blockchain *BlockChain) HeadersAfter(locator [][]byte, limit int) []*BlockHeader {
+	var result []*BlockHeader
+
+	err := blockchain.DB.View(func(tx *bolt.Tx) error {
+		index := tx.Bucket([]byte(heightIndexBucket))
+		headers := tx.Bucket([]byte(headersBucket))
+
+		start := 0
+		for _, hash := range locator {
+			header, err := getHeader(headers, hash)
+			if err == nil && bytes.Equal(index.Get(heightKey(header.Height)), hash) {
+				start = header.Height + 1
+				break
+			}
+		}
+
+		for height := start; len(result) < limit; height++ {
+			hash := index.Get(heightKey(height))
+			if hash == nil {
+				break
+			}
+
+			header, err := getHeader(headers, hash)
+			if err != nil {
+				return err
+			}
+			result = append(result, header)
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return result
+}
+
+func getHeader(headers *bolt.Bucket, hash []byte) (*BlockHeader, error) {
+	data := headers.Get(hash)
+	if data == nil {
+		return nil, errors.New("Block header is not found !!!")
+	}
+
+	return DeserializeBlockHeader(data)
+}
+
+// storeBlock stores the block and its header
+func storeBlock(tx *bolt.Tx, block *Block) error {
+	err := tx.Bucket([]byte(blocksBucket)).Put(block.Hash, block.Serialize())
+	if err != nil {
+		return err
+	}
+
+	headers, err := tx.CreateBucketIfNotExists([]byte(headersBucket))
+	if err != nil {
+		return err
+	}
+
+	return headers.Put(block.Hash, block.BlockHeader.Serialize())
+}
+
+// buildHeaders stores the header of every stored block
+func buildHeaders(tx *bolt.Tx) error {
+	headers, err := tx.CreateBucketIfNotExists([]byte(headersBucket))
+	if err != nil {
+		return err
+	}
+
+	return tx.Bucket([]byte(blocksBucket)).ForEach(func(k, v []byte) error {
+		if bytes.Equal(k, []byte("l")) {
+			return nil
+		}
+
+		block := DeserializeBlock(v)
+		return headers.Put(block.Hash, block.BlockHeader.Serialize())
+	})
+}
@@ -0,0 +1,135 @@
+package features
+
+import (
+	"github.com/boltdb/bolt"
+	"log"
+	"math/big"
+)
+
+// difficulty is expressed as the number of leading zero bits a block hash needs
+const minTargetBits = 8
+const maxTargetBits = 32
+
+// the target is recalculated every retargetInterval blocks so that blocks
+// arrive every targetBlockTime seconds on average
+const retargetInterval = 10
+const targetBlockTime = 10
+const maxRetargetStep = 2
+
+// InitialDifficulty is the difficulty of the genesis block, tests lower it to mine quickly
+var InitialDifficulty = 16
+
+// blocks mined before the difficulty was stored in them all used this fixed difficulty
+const legacyTargetBits = 16
+
+// NextDifficulty returns the difficulty the chain expects for a block built on top of previous
+func (blockchain *BlockChain) NextDifficulty(previous *Block) int {
+	var difficulty int
+
+	err := blockchain.DB.View(func(tx *bolt.Tx) error {
+		difficulty = nextDifficulty(tx.Bucket([]byte(headersBucket)), &previous.BlockHeader)
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return difficulty
+}
+
+func nextDifficulty(headers *bolt.Bucket, previous *BlockHeader) int {
+	height := previous.Height + 1
+	if height%retargetInterval != 0 {
+		return previous.Difficulty
+	}
+
+	first := previous
+	for i := 1; i < retargetInterval && len(first.PreviousHash) != 0; i++ {
+		parent, err := getHeader(headers, first.PreviousHash)
+		if err != nil {
+			break
+		}
+		first = parent
+	}
+
+	actual := previous.TimeStamp - first.TimeStamp
+	expected := int64((previous.Height - first.Height) * targetBlockTime)
+	if actual < 1 {
+		actual = 1
+	}
+
+	return retarget(previous.Difficulty, actual, expected)
+}
+
+// retarget moves the difficulty by one bit for every doubling between the
+// observed and the expected timespan, limited to maxRetargetStep bits
+func retarget(difficulty int, actual, expected int64) int {
+	step := 0
+	for step < maxRetargetStep && actual*2 <= expected {
+		actual *= 2
+		step++
+	}
+	for step > -maxRetargetStep && actual >= expected*2 {
+		expected *= 2
+		step--
+	}
+
+	difficulty += step
+	if difficulty < minTargetBits {
+		difficulty = minTargetBits
+	}
+	if difficulty > maxTargetBits {
+		difficulty = maxTargetBits
+	}
+
+	return difficulty
+}
+
+const chainWorkBucket = "chainwork"
+
+// blockWork is the expected number of hashes needed to mine a block at the given difficulty
+func blockWork(difficulty int) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(difficulty))
+}
+
+// chainWork returns the work accumulated from genesis up to and including header,
+// storing it for the header and any of its ancestors that were not recorded yet
+func chainWork(tx *bolt.Tx, header *BlockHeader) (*big.Int, error) {
+	works, err := tx.CreateBucketIfNotExists([]byte(chainWorkBucket))
+	if err != nil {
+		return nil, err
+	}
+	headers := tx.Bucket([]byte(headersBucket))
+
+	var pending []*BlockHeader
+	work := big.NewInt(0)
+	current := header
+
+	for {
+		if workData := works.Get(current.Hash); workData != nil {
+			work.SetBytes(workData)
+			break
+		}
+
+		pending = append(pending, current)
+		if len(current.PreviousHash) == 0 {
+			break
+		}
+
+		current, err = getHeader(headers, current.PreviousHash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i := len(pending) - 1; i >= 0; i-- {
+		work.Add(work, blockWork(pending[i].Difficulty))
+
+		err = works.Put(pending[i].Hash, work.Bytes())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return work, nil
+}
@@ -0,0 +1,113 @@
+package features
+
+import (
+	"github.com/boltdb/bolt"
+	"testing"
+)
+
+func TestRetarget(t *testing.T) {
+	expected := int64(retargetInterval * targetBlockTime)
+
+	tests := []struct {
+		name       string
+		difficulty int
+		actual     int64
+		result     int
+	}{
+		{"on time", 16, expected, 16},
+		{"slightly fast", 16, expected*2/3 + 1, 16},
+		{"twice as fast", 16, expected / 2, 17},
+		{"four times as fast", 16, expected / 4, 18},
+		{"step is limited", 16, 1, 16 + maxRetargetStep},
+		{"twice as slow", 16, expected * 2, 15},
+		{"slow step is limited", 16, expected * 100, 16 - maxRetargetStep},
+		{"minimum", minTargetBits, expected * 4, minTargetBits},
+		{"maximum", maxTargetBits, 1, maxTargetBits},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := retarget(test.difficulty, test.actual, expected); result != test.result {
+				t.Fatalf("got %d instead of %d", result, test.result)
+			}
+		})
+	}
+}
+
+func TestNextDifficulty(t *testing.T) {
+	blockchain, wallet := newTestChain(t)
+
+	tip := blockchain.Tip
+	for height := 1; height < retargetInterval; height++ {
+		block := addBlock(t, blockchain, tip, wallet)
+		if block.GetDifficulty() != InitialDifficulty {
+			t.Fatalf("block %d has difficulty %d before the retarget", height, block.GetDifficulty())
+		}
+		tip = block.GetHash()
+	}
+
+	// the blocks came in a few milliseconds instead of targetBlockTime seconds each
+	tipBlock, err := blockchain.GetBlock(tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if difficulty := blockchain.NextDifficulty(&tipBlock); difficulty != InitialDifficulty+maxRetargetStep {
+		t.Fatalf("retargeted to %d instead of %d", difficulty, InitialDifficulty+maxRetargetStep)
+	}
+
+	block := buildBlock(t, blockchain, tip, NewCoinbaseTX(walletAddress(wallet), "", retargetInterval, 0))
+	block.Difficulty = InitialDifficulty
+	block.Nonce, block.Hash = NewPOW(&block.BlockHeader).Run()
+	if _, err := blockchain.AddBlock(block); err == nil {
+		t.Fatal("a block ignoring the retarget was accepted")
+	}
+}
+
+func TestLegacyBlocksUseTheFixedDifficulty(t *testing.T) {
+	blockchain := openFixture(t, nil)
+
+	genesis, err := blockchain.GetBlock(blockchain.Tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if genesis.Version != legacyVersion || genesis.GetDifficulty() != legacyTargetBits {
+		t.Fatalf("the baseline block has version %d and difficulty %d", genesis.Version, genesis.GetDifficulty())
+	}
+	if !NewPOW(&genesis.BlockHeader).Validate() {
+		t.Fatal("the baseline block fails its proof of work")
+	}
+
+	header, err := blockchain.GetHeader(genesis.GetHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.GetDifficulty() != legacyTargetBits {
+		t.Fatalf("the stored header has difficulty %d", header.GetDifficulty())
+	}
+
+	err = blockchain.DB.Update(func(tx *bolt.Tx) error {
+		work, err := chainWork(tx, &header)
+		if err != nil {
+			return err
+		}
+		if work.Cmp(blockWork(legacyTargetBits)) != 0 {
+			t.Fatalf("the baseline block carries %s of work", work)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if difficulty := blockchain.NextDifficulty(&genesis); difficulty != legacyTargetBits {
+		t.Fatalf("the block after the baseline one expects difficulty %d", difficulty)
+	}
+
+	// a block at a trivial difficulty on top of the baseline chain is rejected
+	easy := buildBlock(t, blockchain, genesis.GetHash(), NewCoinbaseTX(walletAddress(NewWallet()), "", 1, 0))
+	easy.Difficulty = 0
+	easy.Nonce, easy.Hash = NewPOW(&easy.BlockHeader).Run()
+	if _, err := blockchain.AddBlock(easy); err == nil {
+		t.Fatal("a block with no proof of work was accepted")
+	}
+}
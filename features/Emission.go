@@ -0,0 +1,130 @@
+package features
+
+import (
+	"errors"
+	"github.com/boltdb/bolt"
+)
+
+// EmissionSchedule controls how many new coins the coinbase of each block may mint
+type EmissionSchedule struct {
+	InitialReward   int `yaml:"initialReward"`
+	HalvingInterval int `yaml:"halvingInterval"`
+	MaxSupply       int `yaml:"maxSupply"`
+}
+
+// defaultEmission is the schedule of chains created before it was stored in the meta
+// bucket, the blocks they already have minted its initial reward
+var defaultEmission = EmissionSchedule{
+	InitialReward:   10,
+	HalvingInterval: 1000,
+	MaxSupply:       15000,
+}
+
+// Emission is the schedule of the chain. A new chain takes it from config.yaml and
+// stores it under emissionKey, opening a chain reads it back, so every node on the
+// chain mints and validates with the same schedule whatever its own config.yaml says.
+var Emission = defaultEmission
+
+var emissionKey = []byte("emission")
+
+// Validate checks the schedule can be used for a new chain
+func (schedule EmissionSchedule) Validate() error {
+	if schedule.InitialReward < 0 || schedule.HalvingInterval < 0 || schedule.MaxSupply < 0 {
+		return errors.New("The emission schedule cannot be negative !!!")
+	}
+
+	return nil
+}
+
+// putEmission stores the schedule a chain is created with
+func putEmission(tx *bolt.Tx, schedule EmissionSchedule) error {
+	meta, err := tx.CreateBucketIfNotExists([]byte(metaBucket))
+	if err != nil {
+		return err
+	}
+
+	var e encoder
+	e.writeVarint(int64(schedule.InitialReward))
+	e.writeVarint(int64(schedule.HalvingInterval))
+	e.writeVarint(int64(schedule.MaxSupply))
+
+	return meta.Put(emissionKey, e.Bytes())
+}
+
+// loadEmission makes the schedule stored with the chain the one in use
+func loadEmission(tx *bolt.Tx) error {
+	data := tx.Bucket([]byte(metaBucket)).Get(emissionKey)
+	if data == nil {
+		return errors.New("The emission schedule of the chain is not stored !!!")
+	}
+
+	d := newDecoder(data)
+	schedule := EmissionSchedule{int(d.readVarint()), int(d.readVarint()), int(d.readVarint())}
+	err := d.finish()
+	if err != nil {
+		return err
+	}
+
+	Emission = schedule
+	return nil
+}
+
+// storeDefaultEmission stores the schedule of a chain created before it was stored
+func storeDefaultEmission(tx *bolt.Tx) error {
+	return putEmission(tx, defaultEmission)
+}
+
+// Subsidy returns the coins the coinbase at height may mint on top of the collected fees
+func (schedule EmissionSchedule) Subsidy(height int) int {
+	reward := schedule.reward(height)
+
+	if schedule.MaxSupply > 0 {
+		remaining := schedule.MaxSupply - schedule.Issued(height)
+		if reward > remaining {
+			reward = remaining
+		}
+	}
+
+	return reward
+}
+
+// Issued returns the coins minted by the coinbases of all blocks below height
+func (schedule EmissionSchedule) Issued(height int) int {
+	issued := 0
+
+	for start := 0; start < height; {
+		end := height
+		if schedule.HalvingInterval > 0 {
+			periodEnd := (start/schedule.HalvingInterval + 1) * schedule.HalvingInterval
+			if periodEnd < end {
+				end = periodEnd
+			}
+		}
+
+		reward := schedule.reward(start)
+		if reward == 0 {
+			break
+		}
+		issued += (end - start) * reward
+		start = end
+
+		if schedule.MaxSupply > 0 && issued >= schedule.MaxSupply {
+			return schedule.MaxSupply
+		}
+	}
+
+	return issued
+}
+
+func (schedule EmissionSchedule) reward(height int) int {
+	if schedule.HalvingInterval <= 0 {
+		return schedule.InitialReward
+	}
+
+	halvings := height / schedule.HalvingInterval
+	if halvings >= 63 {
+		return 0
+	}
+
+	return schedule.InitialReward >> uint(halvings)
+}
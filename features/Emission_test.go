@@ -0,0 +1,43 @@
+package features
+
+import "testing"
+
+func TestEmissionIsStoredWithTheChain(t *testing.T) {
+	emission := Emission
+	t.Cleanup(func() { Emission = emission })
+
+	Emission = EmissionSchedule{InitialReward: 50, HalvingInterval: 4, MaxSupply: 300}
+	blockchain, _ := newTestChain(t)
+	blockchain.DB.Close()
+
+	// a node whose config.yaml has another schedule still uses the one of the chain
+	Emission = defaultEmission
+	blockchain = NewBlockChain("test")
+	defer blockchain.DB.Close()
+
+	if Emission != (EmissionSchedule{50, 4, 300}) {
+		t.Fatalf("the chain was opened with the schedule %+v", Emission)
+	}
+	if genesis := genesisTX(t, blockchain); genesis.TXOutputs[0].Value != 50 {
+		t.Fatalf("the genesis coinbase minted %d", genesis.TXOutputs[0].Value)
+	}
+}
+
+func TestEmissionScheduleValidate(t *testing.T) {
+	tests := []struct {
+		schedule EmissionSchedule
+		valid    bool
+	}{
+		{defaultEmission, true},
+		{EmissionSchedule{10, 0, 0}, true},
+		{EmissionSchedule{-1, 1000, 15000}, false},
+		{EmissionSchedule{10, -1, 15000}, false},
+		{EmissionSchedule{10, 1000, -1}, false},
+	}
+
+	for _, test := range tests {
+		if err := test.schedule.Validate(); (err == nil) != test.valid {
+			t.Fatalf("validating %+v returned %v", test.schedule, err)
+		}
+	}
+}
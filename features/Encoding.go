@@ -0,0 +1,459 @@
+package features
+
+// Blocks, transactions and outputs use a canonical binary encoding, so their hashes can
+// be reproduced outside Go. Fixed size integers are big-endian, varints are the signed and
+// unsigned varints of encoding/binary, and byte strings are prefixed by their length as an
+// unsigned varint. Hashes in the header take 32 bytes, the genesis block has a zero PreviousHash.
+//
+//	output       varint Value | bytes PubKeyHash | bytes Script
+//	input        bytes TXid | varint Value | bytes Signature | bytes PublicKey | bytes ScriptSig
+//	transaction  u8 Version | bytes ID (version 0 only) | uvarint count | inputs | uvarint count | outputs
+//	header       u32 Version | 32 PreviousHash | 32 MerkleRoot | i64 TimeStamp | u32 Height |
+//	             u32 Difficulty | i64 Nonce | 32 Hash (version 0 only)
+//	block        header | uvarint count | bytes transaction...
+//	outputs      varint Height | u8 IsCoinbase | uvarint count | (uvarint index | output)... by index
+//	proof        header | bytes transaction | uvarint Index | uvarint count | 32 hash...
+//	undo         uvarint count | (bytes TXid | uvarint Index | output | varint Height | u8 IsCoinbase)...
+//
+// The ID of a version 1 transaction is the SHA-256 of its encoding with the signatures and
+// unlocking scripts left empty, and the hash of a version 1 block is the SHA-256 of the
+// first 92 bytes of its header, which is what the proof of work is done on, so neither is
+// stored. Version 0 marks blocks and transactions migrated from the old gob layout, whose
+// hashes cannot be recomputed and are kept as they were.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+const (
+	legacyVersion      = 0
+	TransactionVersion = 1
+	BlockVersion       = 1
+)
+
+var errBadEncoding = errors.New("encoding is malformed")
+
+type encoder struct {
+	bytes.Buffer
+}
+
+func (e *encoder) writeUvarint(value uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	e.Write(buf[:binary.PutUvarint(buf[:], value)])
+}
+
+func (e *encoder) writeVarint(value int64) {
+	var buf [binary.MaxVarintLen64]byte
+	e.Write(buf[:binary.PutVarint(buf[:], value)])
+}
+
+func (e *encoder) writeInt64(value int64) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(value))
+	e.Write(buf[:])
+}
+
+func (e *encoder) writeBool(value bool) {
+	if value {
+		e.WriteByte(1)
+	} else {
+		e.WriteByte(0)
+	}
+}
+
+func (e *encoder) writeBytes(data []byte) {
+	e.writeUvarint(uint64(len(data)))
+	e.Write(data)
+}
+
+// decoder reads what encoder writes, the first error sticks and later reads return zero values
+type decoder struct {
+	r   *bytes.Reader
+	err error
+}
+
+func newDecoder(data []byte) *decoder {
+	return &decoder{r: bytes.NewReader(data)}
+}
+
+func (d *decoder) fail(err error) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: %v", errBadEncoding, err)
+	}
+}
+
+func (d *decoder) readByte() byte {
+	if d.err != nil {
+		return 0
+	}
+	b, err := d.r.ReadByte()
+	if err != nil {
+		d.fail(err)
+	}
+	return b
+}
+
+func (d *decoder) readBool() bool {
+	return d.readByte() == 1
+}
+
+func (d *decoder) readUvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	value, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		d.fail(err)
+	}
+	return value
+}
+
+func (d *decoder) readVarint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	value, err := binary.ReadVarint(d.r)
+	if err != nil {
+		d.fail(err)
+	}
+	return value
+}
+
+func (d *decoder) readInt64() int64 {
+	var buf [8]byte
+	if d.err != nil {
+		return 0
+	}
+	_, err := io.ReadFull(d.r, buf[:])
+	if err != nil {
+		d.fail(err)
+	}
+	return int64(binary.BigEndian.Uint64(buf[:]))
+}
+
+// readCount reads a length or a count, which can never exceed the bytes left
+func (d *decoder) readCount() int {
+	count := d.readUvarint()
+	if count > uint64(d.r.Len()) {
+		d.fail(errors.New("count exceeds the data left"))
+		return 0
+	}
+	return int(count)
+}
+
+func (d *decoder) readBytes() []byte {
+	length := d.readCount()
+	if d.err != nil || length == 0 {
+		return nil
+	}
+	data := make([]byte, length)
+	_, err := io.ReadFull(d.r, data)
+	if err != nil {
+		d.fail(err)
+	}
+	return data
+}
+
+// finish returns the first error, or an error if data is left over
+func (d *decoder) finish() error {
+	if d.err == nil && d.r.Len() != 0 {
+		d.fail(errors.New("trailing data"))
+	}
+	return d.err
+}
+
+func (out TXOutput) encode(e *encoder) {
+	e.writeVarint(int64(out.Value))
+	e.writeBytes(out.PubKeyHash)
+	e.writeBytes(out.Script)
+}
+
+func decodeOutput(d *decoder) TXOutput {
+	return TXOutput{int(d.readVarint()), d.readBytes(), d.readBytes()}
+}
+
+func (in TXInput) encode(e *encoder) {
+	e.writeBytes(in.TXid)
+	e.writeVarint(int64(in.Value))
+	e.writeBytes(in.Signature)
+	e.writeBytes(in.PublicKey)
+	e.writeBytes(in.ScriptSig)
+}
+
+func decodeInput(d *decoder) TXInput {
+	return TXInput{d.readBytes(), int(d.readVarint()), d.readBytes(), d.readBytes(), d.readBytes()}
+}
+
+func (transaction Transaction) encode(e *encoder) {
+	e.WriteByte(byte(transaction.Version))
+	if transaction.Version == legacyVersion {
+		e.writeBytes(transaction.ID)
+	}
+
+	e.writeUvarint(uint64(len(transaction.TXInputs)))
+	for _, in := range transaction.TXInputs {
+		in.encode(e)
+	}
+
+	e.writeUvarint(uint64(len(transaction.TXOutputs)))
+	for _, out := range transaction.TXOutputs {
+		out.encode(e)
+	}
+}
+
+func decodeTransaction(data []byte) (Transaction, error) {
+	var transaction Transaction
+	d := newDecoder(data)
+
+	transaction.Version = int(d.readByte())
+	if transaction.Version > TransactionVersion {
+		return transaction, fmt.Errorf("%w: transaction version %d is unknown", errBadEncoding, transaction.Version)
+	}
+	if transaction.Version == legacyVersion {
+		transaction.ID = d.readBytes()
+	}
+
+	for i := d.readCount(); i > 0 && d.err == nil; i-- {
+		transaction.TXInputs = append(transaction.TXInputs, decodeInput(d))
+	}
+	for i := d.readCount(); i > 0 && d.err == nil; i-- {
+		transaction.TXOutputs = append(transaction.TXOutputs, decodeOutput(d))
+	}
+
+	err := d.finish()
+	if err != nil {
+		return transaction, err
+	}
+
+	if transaction.Version != legacyVersion {
+		transaction.ID = transaction.UnsignedHash()
+	}
+	return transaction, nil
+}
+
+// header fields are fixed size so the nonce can be changed in place while mining
+const (
+	headerHashLength  = 32
+	headerSize        = 4 + 2*headerHashLength + 8 + 4 + 4 + 8
+	headerNonceOffset = headerSize - 8
+)
+
+func (e *encoder) writeHash(hash []byte) {
+	var buf [headerHashLength]byte
+	copy(buf[:], hash)
+	e.Write(buf[:])
+}
+
+func (d *decoder) readHash() []byte {
+	var buf [headerHashLength]byte
+	if d.err != nil {
+		return nil
+	}
+	_, err := io.ReadFull(d.r, buf[:])
+	if err != nil {
+		d.fail(err)
+	}
+	if buf == [headerHashLength]byte{} {
+		return []byte{}
+	}
+	return buf[:]
+}
+
+func (e *encoder) writeUint32(value int) {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], uint32(value))
+	e.Write(buf[:])
+}
+
+func (d *decoder) readUint32() int {
+	var buf [4]byte
+	if d.err != nil {
+		return 0
+	}
+	_, err := io.ReadFull(d.r, buf[:])
+	if err != nil {
+		d.fail(err)
+	}
+	return int(binary.BigEndian.Uint32(buf[:]))
+}
+
+// encodeFixed writes the part of the header the proof of work is done on
+func (header *BlockHeader) encodeFixed(e *encoder) {
+	e.writeUint32(header.Version)
+	e.writeHash(header.PreviousHash)
+	e.writeHash(header.MerkleRoot)
+	e.writeInt64(header.TimeStamp)
+	e.writeUint32(header.Height)
+	e.writeUint32(header.Difficulty)
+	e.writeInt64(int64(header.Nonce))
+}
+
+func (header *BlockHeader) encode(e *encoder) {
+	header.encodeFixed(e)
+	if header.Version == legacyVersion {
+		e.writeHash(header.Hash)
+	}
+}
+
+// decodeBlockHeader reads a header, whole tells whether it must be all of the data
+func decodeBlockHeader(d *decoder, whole bool) (*BlockHeader, error) {
+	header := &BlockHeader{}
+
+	header.Version = d.readUint32()
+	if d.err == nil && header.Version > BlockVersion {
+		return header, fmt.Errorf("%w: block version %d is unknown", errBadEncoding, header.Version)
+	}
+
+	header.PreviousHash = d.readHash()
+	header.MerkleRoot = d.readHash()
+	header.TimeStamp = d.readInt64()
+	header.Height = d.readUint32()
+	header.Difficulty = d.readUint32()
+	header.Nonce = int(d.readInt64())
+	if header.Version == legacyVersion {
+		header.Hash = d.readHash()
+	}
+
+	if whole {
+		d.finish()
+	}
+	if d.err != nil {
+		return header, d.err
+	}
+
+	if header.Version != legacyVersion {
+		header.Hash = NewPOW(header).hash()
+	} else if header.Difficulty == 0 {
+		// migrated blocks that had no difficulty were mined at the fixed one
+		header.Difficulty = legacyTargetBits
+	}
+	return header, nil
+}
+
+func (block *Block) encode(e *encoder) {
+	block.BlockHeader.encode(e)
+
+	e.writeUvarint(uint64(len(block.Transactions)))
+	for _, transaction := range block.Transactions {
+		e.writeBytes(transaction.Serialize())
+	}
+}
+
+func decodeBlock(data []byte) (*Block, error) {
+	block := &Block{}
+	d := newDecoder(data)
+
+	header, err := decodeBlockHeader(d, false)
+	block.BlockHeader = *header
+	if err != nil {
+		return block, err
+	}
+
+	for i := d.readCount(); i > 0 && d.err == nil; i-- {
+		transaction, err := decodeTransaction(d.readBytes())
+		if err != nil {
+			d.fail(err)
+			break
+		}
+		block.Transactions = append(block.Transactions, &transaction)
+	}
+
+	return block, d.finish()
+}
+
+func (outputs TXOutputs) encode(e *encoder) {
+	e.writeVarint(int64(outputs.Height))
+	e.writeBool(outputs.IsCoinbase)
+
+	var indexes []int
+	for index := range outputs.Outputs {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	e.writeUvarint(uint64(len(indexes)))
+	for _, index := range indexes {
+		e.writeUvarint(uint64(index))
+		outputs.Outputs[index].encode(e)
+	}
+}
+
+func decodeOutputs(data []byte) (TXOutputs, error) {
+	outputs := TXOutputs{Outputs: make(map[int]TXOutput)}
+	d := newDecoder(data)
+
+	outputs.Height = int(d.readVarint())
+	outputs.IsCoinbase = d.readBool()
+
+	for i := d.readCount(); i > 0 && d.err == nil; i-- {
+		index := int(d.readUvarint())
+		outputs.Outputs[index] = decodeOutput(d)
+	}
+
+	return outputs, d.finish()
+}
+
+func (undo BlockUndo) encode(e *encoder) {
+	e.writeUvarint(uint64(len(undo.Spent)))
+	for _, spent := range undo.Spent {
+		e.writeBytes(spent.TXid)
+		e.writeUvarint(uint64(spent.Index))
+		spent.Output.encode(e)
+		e.writeVarint(int64(spent.Height))
+		e.writeBool(spent.IsCoinbase)
+	}
+}
+
+func decodeUndo(data []byte) (BlockUndo, error) {
+	var undo BlockUndo
+	d := newDecoder(data)
+
+	for i := d.readCount(); i > 0 && d.err == nil; i-- {
+		spent := SpentOutput{TXid: d.readBytes(), Index: int(d.readUvarint())}
+		spent.Output = decodeOutput(d)
+		spent.Height = int(d.readVarint())
+		spent.IsCoinbase = d.readBool()
+		undo.Spent = append(undo.Spent, spent)
+	}
+
+	return undo, d.finish()
+}
+
+func (proof *MerkleProof) encode(e *encoder) {
+	proof.Header.encode(e)
+	e.writeBytes(proof.Transaction.Serialize())
+	e.writeUvarint(uint64(proof.Index))
+
+	e.writeUvarint(uint64(len(proof.Path)))
+	for _, hash := range proof.Path {
+		e.writeHash(hash)
+	}
+}
+
+func decodeMerkleProof(data []byte) (*MerkleProof, error) {
+	proof := &MerkleProof{}
+	d := newDecoder(data)
+
+	header, err := decodeBlockHeader(d, false)
+	if err != nil {
+		return nil, err
+	}
+	proof.Header = *header
+
+	proof.Transaction, err = decodeTransaction(d.readBytes())
+	if err != nil {
+		return nil, err
+	}
+	proof.Index = int(d.readUvarint())
+
+	for i := d.readCount(); i > 0 && d.err == nil; i-- {
+		proof.Path = append(proof.Path, d.readHash())
+	}
+
+	return proof, d.finish()
+}
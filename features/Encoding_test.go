@@ -0,0 +1,159 @@
+package features
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// encodingCase is a valid encoding and a decoder that re-encodes what it decoded
+type encodingCase struct {
+	name   string
+	data   []byte
+	decode func(data []byte) ([]byte, error)
+}
+
+func decodeAndSerializeTransaction(data []byte) ([]byte, error) {
+	transaction, err := decodeTransaction(data)
+	return transaction.Serialize(), err
+}
+
+func decodeAndSerializeHeader(data []byte) ([]byte, error) {
+	header, err := decodeBlockHeader(newDecoder(data), true)
+	return header.Serialize(), err
+}
+
+func decodeAndSerializeBlock(data []byte) ([]byte, error) {
+	block, err := decodeBlock(data)
+	return block.Serialize(), err
+}
+
+func decodeAndSerializeOutputs(data []byte) ([]byte, error) {
+	outputs, err := decodeOutputs(data)
+	return outputs.Serialize(), err
+}
+
+func decodeAndSerializeUndo(data []byte) ([]byte, error) {
+	undo, err := decodeUndo(data)
+	return undo.Serialize(), err
+}
+
+func decodeAndSerializeProof(data []byte) ([]byte, error) {
+	proof, err := decodeMerkleProof(data)
+	if err != nil {
+		return nil, err
+	}
+	return proof.Serialize(), nil
+}
+
+func encodingCases() []encodingCase {
+	wallet := NewWallet()
+	pubKeyHash := HashPubKey(wallet.PublicKey)
+
+	coinbase := NewCoinbaseTX(walletAddress(wallet), "", 1, 0)
+	transaction := &Transaction{nil, []TXInput{
+		{coinbase.ID, 0, nil, wallet.PublicKey, nil},
+		{coinbase.ID, 1, nil, nil, NewP2PKHUnlock([]byte{1, 2, 3}, wallet.PublicKey)},
+	}, []TXOutput{
+		*NewScriptTXOutput(7, NewP2PKHScript(pubKeyHash)),
+		{3, pubKeyHash, nil},
+	}, TransactionVersion}
+	transaction.ID = transaction.UnsignedHash()
+
+	legacy := Transaction{bytes.Repeat([]byte{0xab}, 32), []TXInput{{nil, -1, nil, []byte("legacy coinbase"), nil}}, []TXOutput{{10, pubKeyHash, nil}}, legacyVersion}
+	legacyHeader := BlockHeader{legacyVersion, bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32), 1600000000, 3, legacyTargetBits, 42, bytes.Repeat([]byte{3}, 32)}
+
+	block := NewBlock([]*Transaction{coinbase, transaction}, bytes.Repeat([]byte{4}, 32), 1, minTargetBits)
+
+	outputs := TXOutputs{map[int]TXOutput{0: {5, pubKeyHash, nil}, 3: *NewScriptTXOutput(6, []byte{Op1})}, 12, true}
+	undo := BlockUndo{[]SpentOutput{
+		{coinbase.ID, 0, coinbase.TXOutputs[0], 1, true},
+		{transaction.ID, 1, transaction.TXOutputs[1], 2, false},
+	}}
+	proof := &MerkleProof{block.BlockHeader, *transaction, 1, [][]byte{coinbase.ID}}
+
+	return []encodingCase{
+		{"transaction", transaction.Serialize(), decodeAndSerializeTransaction},
+		{"coinbase", coinbase.Serialize(), decodeAndSerializeTransaction},
+		{"legacy transaction", legacy.Serialize(), decodeAndSerializeTransaction},
+		{"header", block.BlockHeader.Serialize(), decodeAndSerializeHeader},
+		{"legacy header", legacyHeader.Serialize(), decodeAndSerializeHeader},
+		{"block", block.Serialize(), decodeAndSerializeBlock},
+		{"outputs", outputs.Serialize(), decodeAndSerializeOutputs},
+		{"undo", undo.Serialize(), decodeAndSerializeUndo},
+		{"empty undo", BlockUndo{}.Serialize(), decodeAndSerializeUndo},
+		{"merkle proof", proof.Serialize(), decodeAndSerializeProof},
+	}
+}
+
+func TestEncodingRoundTrips(t *testing.T) {
+	for _, test := range encodingCases() {
+		t.Run(test.name, func(t *testing.T) {
+			encoded, err := test.decode(test.data)
+			if err != nil {
+				t.Fatalf("decoding failed: %s", err)
+			}
+			if !bytes.Equal(encoded, test.data) {
+				t.Fatalf("re-encoding gave %x instead of %x", encoded, test.data)
+			}
+		})
+	}
+}
+
+func TestEncodingRejectsTruncatedAndTrailingData(t *testing.T) {
+	for _, test := range encodingCases() {
+		t.Run(test.name, func(t *testing.T) {
+			for length := 0; length < len(test.data); length++ {
+				_, err := test.decode(test.data[:length])
+				if !errors.Is(err, errBadEncoding) {
+					t.Fatalf("decoding %d of %d bytes returned %v", length, len(test.data), err)
+				}
+			}
+
+			_, err := test.decode(append(append([]byte{}, test.data...), 0))
+			if !errors.Is(err, errBadEncoding) {
+				t.Fatalf("decoding with a trailing byte returned %v", err)
+			}
+		})
+	}
+}
+
+func TestEncodingRejectsMalformedData(t *testing.T) {
+	var unknownHeader encoder
+	unknownHeader.writeUint32(BlockVersion + 1)
+	unknownHeader.Write(make([]byte, headerSize-4))
+
+	var hugeOutputs encoder
+	hugeOutputs.writeVarint(1)
+	hugeOutputs.writeBool(false)
+	hugeOutputs.writeUvarint(1 << 40)
+
+	tests := []struct {
+		name   string
+		data   []byte
+		decode func(data []byte) ([]byte, error)
+	}{
+		{"transaction of an unknown version", []byte{TransactionVersion + 1, 0, 0}, decodeAndSerializeTransaction},
+		{"transaction with a huge input count", []byte{TransactionVersion, 0xff, 0xff, 0xff, 0xff, 0x0f, 0}, decodeAndSerializeTransaction},
+		{"transaction with a huge script", []byte{TransactionVersion, 0, 1, 2, 0, 0xff, 0xff, 0xff, 0xff, 0x0f}, decodeAndSerializeTransaction},
+		{"transaction with an overlong varint", append([]byte{TransactionVersion}, bytes.Repeat([]byte{0xff}, 11)...), decodeAndSerializeTransaction},
+		{"header of an unknown version", unknownHeader.Bytes(), decodeAndSerializeHeader},
+		{"block of an unknown version", unknownHeader.Bytes(), decodeAndSerializeBlock},
+		{"outputs with a huge count", hugeOutputs.Bytes(), decodeAndSerializeOutputs},
+		{"empty transaction", nil, decodeAndSerializeTransaction},
+		{"empty header", nil, decodeAndSerializeHeader},
+		{"empty block", nil, decodeAndSerializeBlock},
+		{"empty outputs", nil, decodeAndSerializeOutputs},
+		{"empty undo", nil, decodeAndSerializeUndo},
+		{"empty merkle proof", nil, decodeAndSerializeProof},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := test.decode(test.data)
+			if !errors.Is(err, errBadEncoding) {
+				t.Fatalf("decoding returned %v", err)
+			}
+		})
+	}
+}
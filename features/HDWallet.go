@@ -0,0 +1,172 @@
+package features
+
+import (
+	"COMP5567-BlockChain/utils"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// key of the HMAC deriving the master key from a seed, as in SLIP-0010 for P-256
+const hdSeedKey = "Nist256p1 seed"
+
+// child indexes from HardenedOffset on are derived from the private key only
+const HardenedOffset = uint32(0x80000000)
+
+// WalletPath is the derivation path of receiving addresses, the address index is appended to it
+const WalletPath = "m/44'/5567'/0'/0"
+
+// DefaultGapLimit is how many unused addresses in a row end a scan
+const DefaultGapLimit = 20
+
+// bytes of entropy behind a new mnemonic
+const mnemonicEntropyLen = 16
+
+// ExtendedKey is a private key together with the chain code deriving its children
+type ExtendedKey struct {
+	Key       []byte
+	ChainCode []byte
+}
+
+// NewMnemonic returns a new random seed phrase
+func NewMnemonic() string {
+	entropy := make([]byte, mnemonicEntropyLen)
+	_, err := rand.Read(entropy)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	mnemonic, err := utils.EntropyToMnemonic(entropy)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return mnemonic
+}
+
+// MnemonicToSeed checks the seed phrase and stretches it into a 64 byte seed
+func MnemonicToSeed(mnemonic string) ([]byte, error) {
+	_, err := utils.MnemonicToEntropy(mnemonic)
+	if err != nil {
+		return nil, err
+	}
+
+	normalized := strings.Join(strings.Fields(strings.ToLower(mnemonic)), " ")
+	return pbkdf2.Key([]byte(normalized), []byte("mnemonic"), 2048, 64, sha512.New), nil
+}
+
+// NewMasterKey derives the root of the key tree from a seed
+func NewMasterKey(seed []byte) *ExtendedKey {
+	data := seed
+	for {
+		mac := hmac.New(sha512.New, []byte(hdSeedKey))
+		mac.Write(data)
+		sum := mac.Sum(nil)
+
+		key := new(big.Int).SetBytes(sum[:32])
+		if key.Sign() != 0 && key.Cmp(elliptic.P256().Params().N) < 0 {
+			return &ExtendedKey{sum[:32], sum[32:]}
+		}
+		data = sum
+	}
+}
+
+// Child derives the child key at index, indexes from HardenedOffset on are hardened
+func (key *ExtendedKey) Child(index uint32) *ExtendedKey {
+	curve := elliptic.P256()
+	n := curve.Params().N
+
+	var data []byte
+	if index >= HardenedOffset {
+		data = append([]byte{0x00}, key.Key...)
+	} else {
+		x, y := curve.ScalarBaseMult(key.Key)
+		data = elliptic.MarshalCompressed(curve, x, y)
+	}
+	data = append(data, uint32Bytes(index)...)
+
+	for {
+		mac := hmac.New(sha512.New, key.ChainCode)
+		mac.Write(data)
+		sum := mac.Sum(nil)
+
+		tweak := new(big.Int).SetBytes(sum[:32])
+		child := new(big.Int).Add(tweak, new(big.Int).SetBytes(key.Key))
+		child.Mod(child, n)
+		if tweak.Cmp(n) < 0 && child.Sign() != 0 {
+			return &ExtendedKey{child.FillBytes(make([]byte, 32)), sum[32:]}
+		}
+		data = append(append([]byte{0x01}, sum[32:]...), uint32Bytes(index)...)
+	}
+}
+
+// Derive follows a path such as m/44'/5567'/0'/0/3 from this key
+func (key *ExtendedKey) Derive(path string) (*ExtendedKey, error) {
+	indexes, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	current := key
+	for _, index := range indexes {
+		current = current.Child(index)
+	}
+
+	return current, nil
+}
+
+// Wallet returns the key pair of this key
+func (key *ExtendedKey) Wallet(path string) *Wallet {
+	curve := elliptic.P256()
+	private := ecdsa.PrivateKey{D: new(big.Int).SetBytes(key.Key)}
+	private.PublicKey.Curve = curve
+	private.PublicKey.X, private.PublicKey.Y = curve.ScalarBaseMult(key.Key)
+
+	return &Wallet{&private, publicKeyBytes(private.PublicKey), path}
+}
+
+// ParsePath turns a derivation path into child indexes, ' marks hardened indexes
+func ParsePath(path string) ([]uint32, error) {
+	parts := strings.Split(path, "/")
+	if parts[0] != "m" {
+		return nil, fmt.Errorf("Path %s does not start at m !!!", path)
+	}
+
+	var indexes []uint32
+	for _, part := range parts[1:] {
+		offset := uint32(0)
+		if strings.HasSuffix(part, "'") {
+			offset = HardenedOffset
+			part = strings.TrimSuffix(part, "'")
+		}
+
+		index, err := strconv.ParseUint(part, 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("Path %s is not valid !!!", path)
+		}
+		indexes = append(indexes, uint32(index)+offset)
+	}
+
+	return indexes, nil
+}
+
+// walletPath returns the derivation path of the receiving address at index
+func walletPath(index uint32) string {
+	return fmt.Sprintf("%s/%d", WalletPath, index)
+}
+
+func uint32Bytes(value uint32) []byte {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, value)
+	return data
+}
@@ -0,0 +1,89 @@
+package features
+
+import (
+	"COMP5567-BlockChain/utils"
+	"fmt"
+	"github.com/boltdb/bolt"
+)
+
+// maps the height of every block on the main chain to its hash
+const heightIndexBucket = "heights"
+
+// GetBlockByHeight returns the block of the main chain at the height
+func (blockchain *BlockChain) GetBlockByHeight(height int) (Block, error) {
+	var block Block
+
+	err := blockchain.DB.View(func(tx *bolt.Tx) error {
+		found, err := blockAtHeight(tx, height)
+		if err != nil {
+			return err
+		}
+
+		block = *found
+		return nil
+	})
+
+	return block, err
+}
+
+// GetBlocksByHeight returns the blocks of the main chain from one height to another, both
+// included, in ascending order when from is below to and in descending order otherwise
+func (blockchain *BlockChain) GetBlocksByHeight(from, to int) ([]*Block, error) {
+	var blocks []*Block
+
+	step := 1
+	if from > to {
+		step = -1
+	}
+
+	err := blockchain.DB.View(func(tx *bolt.Tx) error {
+		for height := from; height != to+step; height += step {
+			block, err := blockAtHeight(tx, height)
+			if err != nil {
+				return err
+			}
+			blocks = append(blocks, block)
+		}
+		return nil
+	})
+
+	return blocks, err
+}
+
+func blockAtHeight(tx *bolt.Tx, height int) (*Block, error) {
+	index := tx.Bucket([]byte(heightIndexBucket))
+	if index == nil {
+		return nil, fmt.Errorf("Height index is not found, the database needs a migration !!!")
+	}
+
+	hash := index.Get(heightKey(height))
+	if hash == nil {
+		return nil, fmt.Errorf("No block at height %d !!!", height)
+	}
+
+	return DeserializeBlock(tx.Bucket([]byte(blocksBucket)).Get(hash)), nil
+}
+
+// indexHeight records a block joining the main chain
+func indexHeight(tx *bolt.Tx, block *Block) error {
+	index, err := tx.CreateBucketIfNotExists([]byte(heightIndexBucket))
+	if err != nil {
+		return err
+	}
+
+	return index.Put(heightKey(block.Height), block.Hash)
+}
+
+// unindexHeight records a block leaving the main chain
+func unindexHeight(tx *bolt.Tx, block *Block) error {
+	index := tx.Bucket([]byte(heightIndexBucket))
+	if index == nil {
+		return nil
+	}
+
+	return index.Delete(heightKey(block.Height))
+}
+
+func heightKey(height int) []byte {
+	return utils.Int2Hex(int64(height))
+}
@@ -0,0 +1,87 @@
+package features
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+)
+
+// MerkleProof shows a transaction is in a block to a client holding only the block header
+type MerkleProof struct {
+	Header      BlockHeader
+	Transaction Transaction
+	Index       int
+	Path        [][]byte
+}
+
+// GetProof builds the inclusion proof of a main chain transaction
+func (blockchain *BlockChain) GetProof(txID []byte) (*MerkleProof, error) {
+	var proof *MerkleProof
+
+	err := blockchain.DB.View(func(tx *bolt.Tx) error {
+		index := tx.Bucket([]byte(txIndexBucket))
+		if index == nil {
+			return errors.New("Transaction index is not found, the database needs a migration !!!")
+		}
+
+		location := index.Get(txID)
+		if len(location) < 4 {
+			return errors.New("Transaction is not found !!!")
+		}
+		position := int(binary.BigEndian.Uint32(location[len(location)-4:]))
+
+		blockData := tx.Bucket([]byte(blocksBucket)).Get(location[:len(location)-4])
+		if blockData == nil {
+			return errors.New("Block of the transaction is not found !!!")
+		}
+		block := DeserializeBlock(blockData)
+
+		var transactions [][]byte
+		for _, transaction := range block.Transactions {
+			transactions = append(transactions, transaction.Serialize())
+		}
+
+		path, err := NewMerkleTree(transactions).Proof(position)
+		if err != nil {
+			return err
+		}
+
+		proof = &MerkleProof{block.BlockHeader, *block.Transactions[position], position, path}
+		return nil
+	})
+
+	return proof, err
+}
+
+// Verify checks the proof on its own: the header meets its proof of work target and
+// the transaction hashes up to its Merkle root. Whether the header is the one stored
+// on the best chain is up to the client, a migrated header passes with any root.
+func (proof *MerkleProof) Verify() error {
+	if !NewPOW(&proof.Header).Validate() {
+		return ErrBadProofOfWork
+	}
+
+	if proof.Transaction.Version != legacyVersion && !bytes.Equal(proof.Transaction.ID, proof.Transaction.UnsignedHash()) {
+		return fmt.Errorf("%w: transaction %x has a wrong ID", ErrBadMerkleRoot, proof.Transaction.ID)
+	}
+
+	if !VerifyMerkleProof(proof.Header.MerkleRoot, proof.Transaction.Serialize(), proof.Index, proof.Path) {
+		return fmt.Errorf("%w: transaction %x is not in block %x", ErrBadMerkleRoot, proof.Transaction.ID, proof.Header.Hash)
+	}
+
+	return nil
+}
+
+// Serialize returns the canonical encoding of the proof, see Encoding.go
+func (proof *MerkleProof) Serialize() []byte {
+	var e encoder
+	proof.encode(&e)
+
+	return e.Bytes()
+}
+
+func DeserializeMerkleProof(data []byte) (*MerkleProof, error) {
+	return decodeMerkleProof(data)
+}
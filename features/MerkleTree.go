@@ -1,9 +1,14 @@
 package features
 
-import "crypto/sha256"
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+)
 
 type MerkleTree struct {
 	RootNode *MerkleNode
+	levels   [][]MerkleNode
 }
 
 type MerkleNode struct {
@@ -30,6 +35,8 @@ func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
 	return &mNode
 }
 
+// NewMerkleTree hashes the data into leaves and pairs them up to the root, an odd node
+// at any level is paired with a copy of itself
 func NewMerkleTree(data [][]byte) *MerkleTree {
 	var nodes []MerkleNode
 
@@ -37,11 +44,7 @@ func NewMerkleTree(data [][]byte) *MerkleTree {
 		var initData []byte
 		initData = []byte{0x00}
 		nodes = append(nodes, *NewMerkleNode(nil, nil, initData))
-		return &MerkleTree{&nodes[0]}
-	}
-
-	if len(data)%2 != 0 {
-		data = append(data, data[len(data)-1])
+		return &MerkleTree{&nodes[0], [][]MerkleNode{nodes}}
 	}
 
 	for _, d := range data {
@@ -49,17 +52,59 @@ func NewMerkleTree(data [][]byte) *MerkleTree {
 		nodes = append(nodes, *node)
 	}
 
-	for i := 0; i < len(data)/2; i++ {
-		var newLevel []MerkleNode
+	var levels [][]MerkleNode
+	for {
+		if len(nodes)%2 != 0 {
+			nodes = append(nodes, nodes[len(nodes)-1])
+		}
+		levels = append(levels, nodes)
 
+		var newLevel []MerkleNode
 		for j := 0; j < len(nodes); j += 2 {
 			node := NewMerkleNode(&nodes[j], &nodes[j+1], nil)
 			newLevel = append(newLevel, *node)
 		}
 
 		nodes = newLevel
+		if len(nodes) == 1 {
+			break
+		}
 	}
 
-	mTree := MerkleTree{&nodes[0]}
+	mTree := MerkleTree{&nodes[0], append(levels, nodes)}
 	return &mTree
 }
+
+// Proof returns the sibling hashes from the leaf at index up to the root
+func (tree *MerkleTree) Proof(index int) ([][]byte, error) {
+	if index < 0 || len(tree.levels) < 2 || index >= len(tree.levels[0]) {
+		return nil, errors.New("Merkle leaf is not found !!!")
+	}
+
+	var path [][]byte
+	for _, level := range tree.levels[:len(tree.levels)-1] {
+		path = append(path, level[index^1].Value)
+		index /= 2
+	}
+
+	return path, nil
+}
+
+// VerifyMerkleProof tells whether the data is the leaf at index of the tree with the root.
+// It only needs the proof, so clients without the blocks can check an inclusion.
+func VerifyMerkleProof(root, data []byte, index int, path [][]byte) bool {
+	hash := sha256.Sum256(data)
+	value := hash[:]
+
+	for _, sibling := range path {
+		if index%2 == 0 {
+			hash = sha256.Sum256(append(append([]byte{}, value...), sibling...))
+		} else {
+			hash = sha256.Sum256(append(append([]byte{}, sibling...), value...))
+		}
+		value = hash[:]
+		index /= 2
+	}
+
+	return index == 0 && bytes.Equal(value, root)
+}
@@ -0,0 +1,241 @@
+package features
+
+import (
+	"COMP5567-BlockChain/utils"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"github.com/boltdb/bolt"
+)
+
+// the meta bucket holds the schema version of the database under dbVersionKey
+const metaBucket = "meta"
+
+var dbVersionKey = []byte("version")
+
+// migration upgrades a database to version
+type migration struct {
+	version     int
+	description string
+	apply       func(tx *bolt.Tx) error
+}
+
+// migrations in the order they are applied, a new database starts at the last version
+var migrations = []migration{
+	{1, "build the transaction index", buildTXIndex},
+	{2, "build the height index", buildHeightIndex},
+	{3, "re-encode blocks in the canonical format", reencodeBlocks},
+	{4, "store the block headers", buildHeaders},
+	{5, "store the fixed difficulty of migrated blocks", repairLegacyDifficulty},
+	{6, "store the emission schedule", storeDefaultEmission},
+	{7, "store the coinbase maturity", storeDefaultCoinbaseMaturity},
+}
+
+// migrate applies every migration newer than the database, each in its own transaction
+func migrate(db *bolt.DB) error {
+	version := 0
+	err := db.View(func(tx *bolt.Tx) error {
+		version = dbVersion(tx)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+
+		fmt.Printf("Migrating the database to version %d: %s\n", m.version, m.description)
+		err = db.Update(func(tx *bolt.Tx) error {
+			err := m.apply(tx)
+			if err != nil {
+				return err
+			}
+			return setDBVersion(tx, m.version)
+		})
+		if err != nil {
+			return fmt.Errorf("migration to version %d failed: %w", m.version, err)
+		}
+	}
+
+	return nil
+}
+
+// latestDBVersion is the version a new database is created at
+func latestDBVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+func dbVersion(tx *bolt.Tx) int {
+	meta := tx.Bucket([]byte(metaBucket))
+	if meta == nil {
+		return 0
+	}
+
+	version := meta.Get(dbVersionKey)
+	if len(version) != 8 {
+		return 0
+	}
+
+	return int(binary.BigEndian.Uint64(version))
+}
+
+func setDBVersion(tx *bolt.Tx, version int) error {
+	meta, err := tx.CreateBucketIfNotExists([]byte(metaBucket))
+	if err != nil {
+		return err
+	}
+
+	return meta.Put(dbVersionKey, utils.Int2Hex(int64(version)))
+}
+
+// buildTXIndex indexes the transactions of the main chain. The blocks are still
+// in the gob layout at this version.
+func buildTXIndex(tx *bolt.Tx) error {
+	err := tx.DeleteBucket([]byte(txIndexBucket))
+	if err != nil && err != bolt.ErrBucketNotFound {
+		return err
+	}
+
+	return forEachGobBlock(tx, func(block *Block) error {
+		return indexTransactions(tx, block)
+	})
+}
+
+// buildHeightIndex indexes the heights of the main chain, the blocks are still in the gob layout
+func buildHeightIndex(tx *bolt.Tx) error {
+	err := tx.DeleteBucket([]byte(heightIndexBucket))
+	if err != nil && err != bolt.ErrBucketNotFound {
+		return err
+	}
+
+	return forEachGobBlock(tx, func(block *Block) error {
+		return indexHeight(tx, block)
+	})
+}
+
+// forEachGobBlock walks the main chain of a database whose blocks are in the gob layout, from the tip
+func forEachGobBlock(tx *bolt.Tx, apply func(block *Block) error) error {
+	b := tx.Bucket([]byte(blocksBucket))
+	hash := b.Get([]byte("l"))
+
+	for len(hash) != 0 {
+		block, err := decodeGobBlock(b.Get(hash))
+		if err != nil {
+			return fmt.Errorf("block %x: %w", hash, err)
+		}
+
+		err = apply(block)
+		if err != nil {
+			return err
+		}
+		hash = block.PreviousHash
+	}
+
+	return nil
+}
+
+// decodeGobBlock reads a block written before version 3 as a version 0 block. Blocks
+// written before the difficulty was stored in them were mined at the fixed one.
+func decodeGobBlock(data []byte) (*Block, error) {
+	var legacy struct {
+		TimeStamp    int64
+		Transactions []*Transaction
+		PreviousHash []byte
+		Hash         []byte
+		Nonce        int
+		Height       int
+		Difficulty   int
+	}
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&legacy)
+	if err != nil {
+		return nil, err
+	}
+
+	if legacy.Difficulty == 0 {
+		legacy.Difficulty = legacyTargetBits
+	}
+	for _, transaction := range legacy.Transactions {
+		transaction.Version = legacyVersion
+	}
+
+	block := &Block{Transactions: legacy.Transactions}
+	block.BlockHeader = BlockHeader{legacyVersion, legacy.PreviousHash, block.HashTransactions(), legacy.TimeStamp,
+		legacy.Height, legacy.Difficulty, legacy.Nonce, legacy.Hash}
+
+	return block, nil
+}
+
+// reencodeBlocks rewrites every stored block from gob to the canonical encoding, keeping
+// their hashes and transaction IDs as version 0, then rebuilds the chainstate from them
+func reencodeBlocks(tx *bolt.Tx) error {
+	b := tx.Bucket([]byte(blocksBucket))
+
+	var keys [][]byte
+	err := b.ForEach(func(k, v []byte) error {
+		if !bytes.Equal(k, []byte("l")) {
+			keys = append(keys, append([]byte{}, k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		block, err := decodeGobBlock(b.Get(key))
+		if err != nil {
+			return fmt.Errorf("block %x: %w", key, err)
+		}
+
+		err = b.Put(key, block.Serialize())
+		if err != nil {
+			return err
+		}
+	}
+
+	return reindexChainstate(tx)
+}
+
+// repairLegacyDifficulty stores the fixed difficulty in the blocks and headers version 3
+// migrated with none, and drops the chain work that was accumulated from it
+func repairLegacyDifficulty(tx *bolt.Tx) error {
+	blocks := tx.Bucket([]byte(blocksBucket))
+	headers := tx.Bucket([]byte(headersBucket))
+
+	var keys [][]byte
+	err := blocks.ForEach(func(k, v []byte) error {
+		if !bytes.Equal(k, []byte("l")) {
+			keys = append(keys, append([]byte{}, k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		block := DeserializeBlock(blocks.Get(key))
+		if block.Version != legacyVersion {
+			continue
+		}
+
+		err = blocks.Put(key, block.Serialize())
+		if err != nil {
+			return err
+		}
+		err = headers.Put(key, block.BlockHeader.Serialize())
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.DeleteBucket([]byte(chainWorkBucket))
+	if err != nil && err != bolt.ErrBucketNotFound {
+		return err
+	}
+	return nil
+}
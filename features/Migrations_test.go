@@ -0,0 +1,153 @@
+package features
+
+import (
+	"bytes"
+	"github.com/boltdb/bolt"
+	"testing"
+)
+
+// migrateTo applies the migrations up to version as the releases that stopped there did
+func migrateTo(t *testing.T, db *bolt.DB, version int) {
+	t.Helper()
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, m := range migrations {
+			if m.version > version {
+				break
+			}
+
+			err := m.apply(tx)
+			if err != nil {
+				return err
+			}
+			err = setDBVersion(tx, m.version)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// zeroLegacyDifficulty rewrites the migrated blocks and headers with the difficulty of 0 the
+// first release of version 3 stored, along with the chain work it accumulated from it
+func zeroLegacyDifficulty(t *testing.T, db *bolt.DB) {
+	t.Helper()
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		blocks := tx.Bucket([]byte(blocksBucket))
+		block := DeserializeBlock(blocks.Get(blocks.Get([]byte("l"))))
+		block.Difficulty = 0
+
+		err := blocks.Put(block.Hash, block.Serialize())
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket([]byte(headersBucket)).Put(block.Hash, block.BlockHeader.Serialize())
+		if err != nil {
+			return err
+		}
+
+		works, err := tx.CreateBucketIfNotExists([]byte(chainWorkBucket))
+		if err != nil {
+			return err
+		}
+		return works.Put(block.Hash, blockWork(0).Bytes())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrationsFromBaseline(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(t *testing.T, db *bolt.DB)
+	}{
+		{"baseline", func(t *testing.T, db *bolt.DB) {}},
+		{"version 1", func(t *testing.T, db *bolt.DB) { migrateTo(t, db, 1) }},
+		{"version 2", func(t *testing.T, db *bolt.DB) { migrateTo(t, db, 2) }},
+		{"version 3", func(t *testing.T, db *bolt.DB) { migrateTo(t, db, 3) }},
+		{"version 4 with no difficulty", func(t *testing.T, db *bolt.DB) {
+			migrateTo(t, db, 4)
+			zeroLegacyDifficulty(t, db)
+		}},
+		{"version 5", func(t *testing.T, db *bolt.DB) { migrateTo(t, db, 5) }},
+		{"version 6", func(t *testing.T, db *bolt.DB) { migrateTo(t, db, 6) }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			blockchain := openFixture(t, func(db *bolt.DB) {
+				test.prepare(t, db)
+				Emission, CoinbaseMaturity = EmissionSchedule{1, 2, 3}, 4
+			})
+			if Emission != defaultEmission || CoinbaseMaturity != defaultCoinbaseMaturity {
+				t.Fatalf("the migrated chain uses the schedule %+v and maturity %d", Emission, CoinbaseMaturity)
+			}
+
+			err := blockchain.DB.Update(func(tx *bolt.Tx) error {
+				if version := dbVersion(tx); version != latestDBVersion() {
+					t.Fatalf("the database is at version %d", version)
+				}
+
+				blocks := tx.Bucket([]byte(blocksBucket))
+				tip := blocks.Get([]byte("l"))
+				genesis := DeserializeBlock(blocks.Get(tip))
+				if genesis.Version != legacyVersion || genesis.Difficulty != legacyTargetBits {
+					t.Fatalf("the block has version %d and difficulty %d", genesis.Version, genesis.Difficulty)
+				}
+
+				header, err := getHeader(tx.Bucket([]byte(headersBucket)), tip)
+				if err != nil {
+					return err
+				}
+				if header.Difficulty != legacyTargetBits {
+					t.Fatalf("the header has difficulty %d", header.Difficulty)
+				}
+
+				work, err := chainWork(tx, header)
+				if err != nil {
+					return err
+				}
+				if work.Cmp(blockWork(legacyTargetBits)) != 0 {
+					t.Fatalf("the chain carries %s of work", work)
+				}
+
+				coinbase := genesis.Transactions[0]
+				indexed, err := lookupTransaction(tx, coinbase.ID)
+				if err != nil {
+					return err
+				}
+				if !bytes.Equal(indexed.Hash(), coinbase.ID) {
+					t.Fatalf("the index returned transaction %x", indexed.ID)
+				}
+
+				atHeight, err := blockAtHeight(tx, 0)
+				if err != nil {
+					return err
+				}
+				if !bytes.Equal(atHeight.Hash, tip) {
+					t.Fatalf("height 0 is indexed as %x", atHeight.Hash)
+				}
+
+				outs := tx.Bucket([]byte(UTXOBucket)).Get(coinbase.ID)
+				if outs == nil || len(DeserializeOutputs(outs).Outputs) != 1 {
+					t.Fatal("the genesis output is not in the chainstate")
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := blockchain.AddBlock(buildBlock(t, blockchain, blockchain.Tip,
+				NewCoinbaseTX(walletAddress(NewWallet()), "", 1, 0))); err != nil {
+				t.Fatalf("a block on top of the migrated chain was rejected: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package features
+
+import (
+	"errors"
+)
+
+// MultisigWallet is an address spendable by M of its public keys, it holds no private keys
+type MultisigWallet struct {
+	M            int
+	PublicKeys   [][]byte
+	RedeemScript []byte
+}
+
+// NewMultisigWallet creates the m-of-n multisig wallet of the public keys
+func NewMultisigWallet(m int, publicKeys [][]byte) (*MultisigWallet, error) {
+	if len(publicKeys) == 0 || len(publicKeys) > 16 {
+		return nil, errors.New("A multisig address needs between 1 and 16 public keys !!!")
+	}
+	if m < 1 || m > len(publicKeys) {
+		return nil, errors.New("The number of required signatures is out of range !!!")
+	}
+
+	wallet := MultisigWallet{m, publicKeys, NewMultisigScript(m, publicKeys)}
+	return &wallet, nil
+}
+
+// GetAddress returns the pay-to-script-hash address of the redeem script
+func (w MultisigWallet) GetAddress() []byte {
+	return encodeAddress(scriptVersion, HashPubKey(w.RedeemScript))
+}
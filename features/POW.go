@@ -1,9 +1,9 @@
 package features
 
 import (
-	"COMP5567-BlockChain/utils"
 	"bytes"
 	"crypto/sha256"
+	"encoding/binary"
 	"fmt"
 	"math"
 	"math/big"
@@ -11,43 +11,38 @@ import (
 
 var maxNonce = math.MaxInt64
 
-const targetBits = 16
-
 type POW struct {
-	block  *Block
+	block  *BlockHeader
 	target *big.Int
 }
 
-func NewPOW(block *Block) *POW {
+func NewPOW(block *BlockHeader) *POW {
 	target := big.NewInt(1)
-	target.Lsh(target, uint(256-targetBits))
+	target.Lsh(target, uint(256-block.Difficulty))
 
 	pow := &POW{block, target}
 	return pow
 }
 
+// prepareData returns the fixed-size header at the nonce
 func (pow *POW) prepareData(nonce int) []byte {
-	data := bytes.Join(
-		[][]byte{
-			pow.block.PreviousHash,
-			pow.block.HashTransactions(),
-			utils.Int2Hex(pow.block.TimeStamp),
-			utils.Int2Hex(int64(targetBits)),
-			utils.Int2Hex(int64(nonce)),
-		},
-		[]byte{},
-	)
-	return data
+	var e encoder
+	header := *pow.block
+	header.Nonce = nonce
+	header.encodeFixed(&e)
+
+	return e.Bytes()
 }
 
 func (pow *POW) Run() (int, []byte) {
 	var hashInt big.Int
 	var hash [32]byte
 	nonce := 0
+	data := pow.prepareData(nonce)
 
 	fmt.Println("Mining a new Block...")
 	for nonce < maxNonce {
-		data := pow.prepareData(nonce)
+		binary.BigEndian.PutUint64(data[headerNonceOffset:], uint64(nonce))
 
 		hash = sha256.Sum256(data)
 		if math.Remainder(float64(nonce), 100000) == 0 {
@@ -65,13 +60,25 @@ func (pow *POW) Run() (int, []byte) {
 	return nonce, hash[:]
 }
 
+// hash returns the proof of work hash of the block at its nonce
+func (pow *POW) hash() []byte {
+	hash := sha256.Sum256(pow.prepareData(pow.block.Nonce))
+	return hash[:]
+}
+
+// Validate checks the block hash meets the target. Migrated blocks were hashed over gob,
+// which cannot be reproduced, so only their stored hash is checked against the target.
 func (pow *POW) Validate() bool {
 	var hashInt big.Int
 
-	data := pow.prepareData(pow.block.Nonce)
-	hash := sha256.Sum256(data)
-	hashInt.SetBytes(hash[:])
+	if pow.block.Version == legacyVersion {
+		hashInt.SetBytes(pow.block.Hash)
+		return hashInt.Cmp(pow.target) == -1
+	}
+
+	hash := pow.hash()
+	hashInt.SetBytes(hash)
 
-	isValid := hashInt.Cmp(pow.target) == -1
+	isValid := hashInt.Cmp(pow.target) == -1 && bytes.Equal(hash, pow.block.Hash)
 	return isValid
 }
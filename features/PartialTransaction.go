@@ -0,0 +1,222 @@
+package features
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// PartialTransaction is a spend from a multisig address passed between the key
+// holders, each of them adds signatures until enough keys signed every input
+type PartialTransaction struct {
+	Transaction  Transaction
+	RedeemScript []byte
+	PreviousOuts []TXOutput
+	Signatures   []map[string][]byte
+}
+
+// NewMultisigTransaction builds an unsigned transaction sending amount from the multisig wallet to the address
+func NewMultisigTransaction(wallet *MultisigWallet, to string, amount, fee int, UTXOSet *UTXOSet) (*PartialTransaction, error) {
+	var inputs []TXInput
+	var outputs []TXOutput
+	var previousOuts []TXOutput
+
+	scriptHash := HashPubKey(wallet.RedeemScript)
+	acc, validOutputs := UTXOSet.FindSpendableOutputs(scriptHash, amount+fee)
+
+	if acc < amount+fee {
+		return nil, errors.New("Not enough funds !!!")
+	}
+
+	for id, outs := range validOutputs {
+		txID, err := hex.DecodeString(id)
+		if err != nil {
+			return nil, err
+		}
+
+		previousTX, err := UTXOSet.BlockChain.FindTransaction(txID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, out := range outs {
+			inputs = append(inputs, TXInput{txID, out, nil, nil, nil})
+			previousOuts = append(previousOuts, previousTX.TXOutputs[out])
+		}
+	}
+
+	from := fmt.Sprintf("%s", wallet.GetAddress())
+	outputs = append(outputs, *NewTXOutput(amount, to))
+	if acc > amount+fee {
+		outputs = append(outputs, *NewTXOutput(acc-amount-fee, from))
+	}
+
+	transaction := Transaction{nil, inputs, outputs, TransactionVersion}
+	transaction.ID = transaction.Hash()
+
+	signatures := make([]map[string][]byte, len(inputs))
+	for i := range signatures {
+		signatures[i] = make(map[string][]byte)
+	}
+
+	return &PartialTransaction{transaction, wallet.RedeemScript, previousOuts, signatures}, nil
+}
+
+// Sign adds the signatures of one key holder to every input
+func (ptx *PartialTransaction) Sign(privateKey ecdsa.PrivateKey, publicKey []byte) error {
+	_, publicKeys, err := ParseMultisigScript(ptx.RedeemScript)
+	if err != nil {
+		return err
+	}
+
+	isSigner := false
+	for _, key := range publicKeys {
+		isSigner = isSigner || bytes.Equal(key, publicKey)
+	}
+	if !isSigner {
+		return errors.New("The key is not part of the multisig address !!!")
+	}
+
+	for id := range ptx.Transaction.TXInputs {
+		signature := ptx.Transaction.SignInput(id, privateKey, ptx.PreviousOuts[id])
+		ptx.signaturesOf(id)[hex.EncodeToString(publicKey)] = signature
+	}
+
+	return nil
+}
+
+// Combine merges the signatures collected in another copy of the same transaction
+func (ptx *PartialTransaction) Combine(other *PartialTransaction) error {
+	if !bytes.Equal(ptx.Transaction.ID, other.Transaction.ID) || !bytes.Equal(ptx.RedeemScript, other.RedeemScript) {
+		return errors.New("The partial transactions spend different outputs !!!")
+	}
+
+	for id, signatures := range other.Signatures {
+		for publicKey, signature := range signatures {
+			ptx.signaturesOf(id)[publicKey] = signature
+		}
+	}
+
+	return nil
+}
+
+// signaturesOf returns the signatures of an input, gob decodes empty maps as nil
+func (ptx *PartialTransaction) signaturesOf(input int) map[string][]byte {
+	for len(ptx.Signatures) <= input {
+		ptx.Signatures = append(ptx.Signatures, nil)
+	}
+	if ptx.Signatures[input] == nil {
+		ptx.Signatures[input] = make(map[string][]byte)
+	}
+
+	return ptx.Signatures[input]
+}
+
+// SignatureCount returns the number of signatures collected for the input with the fewest
+func (ptx *PartialTransaction) SignatureCount() int {
+	count := -1
+	for id := range ptx.Transaction.TXInputs {
+		if signatures := len(ptx.signaturesOf(id)); count == -1 || signatures < count {
+			count = signatures
+		}
+	}
+
+	return count
+}
+
+// Fee returns what the spent outputs hold beyond the new outputs. The values of PreviousOuts
+// are not signed, CheckPreviousOuts tells whether they are the ones in the chain.
+func (ptx *PartialTransaction) Fee() int {
+	fee := 0
+	for _, out := range ptx.PreviousOuts {
+		fee += out.Value
+	}
+	for _, out := range ptx.Transaction.TXOutputs {
+		fee -= out.Value
+	}
+
+	return fee
+}
+
+// CheckPreviousOuts compares PreviousOuts with the outputs the inputs spend in the chain,
+// a file claiming other values could hide the fee from the signers
+func (ptx *PartialTransaction) CheckPreviousOuts(blockchain *BlockChain) error {
+	if len(ptx.PreviousOuts) != len(ptx.Transaction.TXInputs) {
+		return errors.New("The partial transaction does not list the outputs it spends !!!")
+	}
+
+	for id, in := range ptx.Transaction.TXInputs {
+		previousTX, err := blockchain.FindTransaction(in.TXid)
+		if err != nil {
+			return err
+		}
+		if in.Value < 0 || in.Value >= len(previousTX.TXOutputs) {
+			return fmt.Errorf("Input %d spends an output that does not exist !!!", id)
+		}
+
+		out, claimed := previousTX.TXOutputs[in.Value], ptx.PreviousOuts[id]
+		if out.Value != claimed.Value || !bytes.Equal(out.LockingScript(), claimed.LockingScript()) {
+			return fmt.Errorf("Input %d does not spend the output the partial transaction lists !!!", id)
+		}
+	}
+
+	return nil
+}
+
+// Finalize builds the unlocking scripts from the collected signatures once every input has enough of them
+func (ptx *PartialTransaction) Finalize() (*Transaction, error) {
+	m, publicKeys, err := ParseMultisigScript(ptx.RedeemScript)
+	if err != nil {
+		return nil, err
+	}
+
+	transaction := ptx.Transaction
+	transaction.TXInputs = append([]TXInput{}, ptx.Transaction.TXInputs...)
+
+	for id := range transaction.TXInputs {
+		var signatures [][]byte
+		hash := transaction.sigHash(id, ptx.PreviousOuts[id])
+
+		for _, publicKey := range publicKeys {
+			signature := ptx.signaturesOf(id)[hex.EncodeToString(publicKey)]
+			if len(signatures) < m && verifySignature(hash, signature, publicKey) {
+				signatures = append(signatures, signature)
+			}
+		}
+
+		if len(signatures) < m {
+			return nil, fmt.Errorf("Input %d has %d of the %d required signatures !!!", id, len(signatures), m)
+		}
+
+		transaction.TXInputs[id].ScriptSig = NewP2SHUnlock(NewMultisigUnlock(signatures), ptx.RedeemScript)
+	}
+
+	return &transaction, nil
+}
+
+func (ptx PartialTransaction) Serialize() []byte {
+	var buff bytes.Buffer
+	encoder := gob.NewEncoder(&buff)
+	err := encoder.Encode(ptx)
+	if err != nil {
+		log.Panic("Serialization Error!!!!", err)
+	}
+
+	return buff.Bytes()
+}
+
+func DeserializePartialTransaction(data []byte) (*PartialTransaction, error) {
+	var ptx PartialTransaction
+
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	err := decoder.Decode(&ptx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ptx, nil
+}
@@ -0,0 +1,42 @@
+package features
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPartialTransactionPreviousOuts(t *testing.T) {
+	blockchain, wallet := newTestChain(t)
+	other := NewWallet()
+	multisig, err := NewMultisigWallet(2, [][]byte{wallet.PublicKey, other.PublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	genesis := genesisTX(t, blockchain)
+	value := genesis.TXOutputs[0].Value
+	funding := spend(t, blockchain, wallet, genesis, 0, *NewTXOutput(value, fmt.Sprintf("%s", multisig.GetAddress())))
+	addBlock(t, blockchain, blockchain.Tip, wallet, funding)
+
+	UTXOSet := UTXOSet{BlockChain: blockchain}
+	ptx, err := NewMultisigTransaction(multisig, walletAddress(other), value-3, 2, &UTXOSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fee := ptx.Fee(); fee != 2 {
+		t.Fatalf("the fee is %d instead of 2", fee)
+	}
+	if err = ptx.CheckPreviousOuts(blockchain); err != nil {
+		t.Fatal(err)
+	}
+
+	ptx.PreviousOuts[0].Value += 5
+	if err = ptx.CheckPreviousOuts(blockchain); err == nil {
+		t.Fatal("a partial transaction claiming a larger input passed")
+	}
+
+	ptx.PreviousOuts = nil
+	if err = ptx.CheckPreviousOuts(blockchain); err == nil {
+		t.Fatal("a partial transaction without the outputs it spends passed")
+	}
+}
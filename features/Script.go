@@ -0,0 +1,524 @@
+package features
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// opcodes of the locking script language, a small stack machine modelled on Bitcoin script.
+// Opcodes 0x01-0x4b push the next n bytes onto the stack.
+const (
+	Op0                   = 0x00
+	OpPushData1           = 0x4c
+	OpPushData2           = 0x4d
+	Op1                   = 0x51
+	Op16                  = 0x60
+	OpIf                  = 0x63
+	OpNotIf               = 0x64
+	OpElse                = 0x67
+	OpEndIf               = 0x68
+	OpVerify              = 0x69
+	OpReturn              = 0x6a
+	OpDrop                = 0x75
+	OpDup                 = 0x76
+	OpSwap                = 0x7c
+	OpEqual               = 0x87
+	OpEqualVerify         = 0x88
+	OpSha256              = 0xa8
+	OpHash160             = 0xa9
+	OpCheckSig            = 0xac
+	OpCheckSigVerify      = 0xad
+	OpCheckMultisig       = 0xae
+	OpCheckLockTimeVerify = 0xb1
+)
+
+const maxScriptSize = 10000
+const maxStackSize = 1000
+
+var ErrScriptFailed = errors.New("script evaluation failed")
+
+// scriptContext gives the interpreter access to the spending transaction
+type scriptContext struct {
+	transaction *Transaction
+	input       int
+	previousOut TXOutput
+	height      int
+}
+
+func (ctx scriptContext) checkSignature(signature, publicKey []byte) bool {
+	return verifySignature(ctx.transaction.sigHash(ctx.input, ctx.previousOut), signature, publicKey)
+}
+
+// NewP2PKHScript locks an output to the owner of the public key hashing to pubKeyHash
+func NewP2PKHScript(pubKeyHash []byte) []byte {
+	var script []byte
+	script = append(script, OpDup, OpHash160)
+	script = append(script, pushData(pubKeyHash)...)
+	script = append(script, OpEqualVerify, OpCheckSig)
+
+	return script
+}
+
+// NewP2SHScript locks an output to a redeem script hashing to scriptHash, the spender
+// reveals the redeem script as the last push of its unlocking script
+func NewP2SHScript(scriptHash []byte) []byte {
+	var script []byte
+	script = append(script, OpHash160)
+	script = append(script, pushData(scriptHash)...)
+	script = append(script, OpEqual)
+
+	return script
+}
+
+func isP2SHScript(script []byte) bool {
+	return len(script) == 23 && script[0] == OpHash160 && script[1] == 20 && script[22] == OpEqual
+}
+
+// NewMultisigScript locks an output so that m of the public keys have to sign
+func NewMultisigScript(m int, publicKeys [][]byte) []byte {
+	var script []byte
+	script = append(script, pushInt(int64(m))...)
+	for _, publicKey := range publicKeys {
+		script = append(script, pushData(publicKey)...)
+	}
+	script = append(script, pushInt(int64(len(publicKeys)))...)
+	script = append(script, OpCheckMultisig)
+
+	return script
+}
+
+// NewHTLCScript locks an output so that the recipient can claim it by revealing the
+// preimage of secretHash, or the sender can take it back once lockHeight is reached
+func NewHTLCScript(secretHash, recipientPubKeyHash, refundPubKeyHash []byte, lockHeight int) []byte {
+	var script []byte
+	script = append(script, OpIf, OpSha256)
+	script = append(script, pushData(secretHash)...)
+	script = append(script, OpEqualVerify, OpDup, OpHash160)
+	script = append(script, pushData(recipientPubKeyHash)...)
+	script = append(script, OpElse)
+	script = append(script, pushInt(int64(lockHeight))...)
+	script = append(script, OpCheckLockTimeVerify, OpDrop, OpDup, OpHash160)
+	script = append(script, pushData(refundPubKeyHash)...)
+	script = append(script, OpEndIf, OpEqualVerify, OpCheckSig)
+
+	return script
+}
+
+// NewP2PKHUnlock builds the unlocking script of a pay-to-pubkey-hash output
+func NewP2PKHUnlock(signature, publicKey []byte) []byte {
+	return append(pushData(signature), pushData(publicKey)...)
+}
+
+// NewMultisigUnlock builds the unlocking script of a multisig output, the signatures
+// have to be in the same order as their public keys in the locking script
+func NewMultisigUnlock(signatures [][]byte) []byte {
+	var script []byte
+	for _, signature := range signatures {
+		script = append(script, pushData(signature)...)
+	}
+
+	return script
+}
+
+// NewP2SHUnlock wraps the unlocking script of a redeem script for a pay-to-script-hash output
+func NewP2SHUnlock(unlock, redeemScript []byte) []byte {
+	return append(append([]byte{}, unlock...), pushData(redeemScript)...)
+}
+
+// ParseMultisigScript returns the number of required signatures and the public keys of a multisig script
+func ParseMultisigScript(script []byte) (int, [][]byte, error) {
+	var pushes [][]byte
+	var ops []byte
+
+	for pc := 0; pc < len(script); {
+		op, data, next, err := readOp(script, pc)
+		if err != nil {
+			return 0, nil, err
+		}
+		ops = append(ops, op)
+		pushes = append(pushes, data)
+		pc = next
+	}
+
+	count := len(ops)
+	if count < 4 || ops[count-1] != OpCheckMultisig {
+		return 0, nil, errors.New("Script is not a multisig script !!!")
+	}
+
+	m, n := int(ops[0])-Op1+1, int(ops[count-2])-Op1+1
+	if m < 1 || m > 16 || n < m || n > 16 || n != count-3 {
+		return 0, nil, errors.New("Script is not a multisig script !!!")
+	}
+
+	return m, pushes[1 : count-2], nil
+}
+
+// NewHTLCClaim builds the unlocking script the recipient of a hash-timelock output uses
+func NewHTLCClaim(signature, publicKey, secret []byte) []byte {
+	script := NewP2PKHUnlock(signature, publicKey)
+	script = append(script, pushData(secret)...)
+
+	return append(script, Op1)
+}
+
+// NewHTLCRefund builds the unlocking script the sender of a hash-timelock output uses after the timeout
+func NewHTLCRefund(signature, publicKey []byte) []byte {
+	return append(NewP2PKHUnlock(signature, publicKey), Op0)
+}
+
+func pushData(data []byte) []byte {
+	switch {
+	case len(data) < OpPushData1:
+		return append([]byte{byte(len(data))}, data...)
+	case len(data) <= 0xff:
+		return append([]byte{OpPushData1, byte(len(data))}, data...)
+	default:
+		return append([]byte{OpPushData2, byte(len(data)), byte(len(data) >> 8)}, data...)
+	}
+}
+
+func pushInt(number int64) []byte {
+	if number == 0 {
+		return []byte{Op0}
+	}
+	if number > 0 && number <= 16 {
+		return []byte{byte(Op1 - 1 + number)}
+	}
+
+	return pushData(encodeScriptNumber(number))
+}
+
+// numbers on the stack are big endian two's complement of at most 8 bytes
+func encodeScriptNumber(number int64) []byte {
+	var data []byte
+	for i := 7; i >= 0; i-- {
+		data = append(data, byte(number>>(uint(i)*8)))
+	}
+
+	return data
+}
+
+func decodeScriptNumber(data []byte) (int64, error) {
+	if len(data) > 8 {
+		return 0, fmt.Errorf("%w: number is longer than 8 bytes", ErrScriptFailed)
+	}
+
+	var number int64
+	for _, b := range data {
+		number = number<<8 | int64(b)
+	}
+	if len(data) > 0 && len(data) < 8 && data[0]&0x80 != 0 {
+		number -= 1 << (uint(len(data)) * 8)
+	}
+
+	return number, nil
+}
+
+func isTrue(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readOp returns the opcode at pc, the data it pushes and the position of the next opcode
+func readOp(script []byte, pc int) (byte, []byte, int, error) {
+	op := script[pc]
+	pc++
+
+	length := 0
+	switch {
+	case op > Op0 && op < OpPushData1:
+		length = int(op)
+	case op == OpPushData1:
+		if pc+1 > len(script) {
+			return 0, nil, 0, fmt.Errorf("%w: truncated push", ErrScriptFailed)
+		}
+		length = int(script[pc])
+		pc++
+	case op == OpPushData2:
+		if pc+2 > len(script) {
+			return 0, nil, 0, fmt.Errorf("%w: truncated push", ErrScriptFailed)
+		}
+		length = int(script[pc]) | int(script[pc+1])<<8
+		pc += 2
+	default:
+		return op, nil, pc, nil
+	}
+
+	if pc+length > len(script) {
+		return 0, nil, 0, fmt.Errorf("%w: truncated push", ErrScriptFailed)
+	}
+
+	return op, script[pc : pc+length], pc + length, nil
+}
+
+func isPushOnly(script []byte) bool {
+	for pc := 0; pc < len(script); {
+		op, _, next, err := readOp(script, pc)
+		if err != nil || op > Op16 {
+			return false
+		}
+		pc = next
+	}
+
+	return true
+}
+
+// evalScript runs the unlocking script followed by the locking script and
+// succeeds when the stack ends with a true value on top
+func evalScript(unlock, lock []byte, ctx scriptContext) error {
+	if !isPushOnly(unlock) {
+		return fmt.Errorf("%w: unlocking script may only push data", ErrScriptFailed)
+	}
+
+	stack, err := execute(nil, unlock, ctx)
+	if err != nil {
+		return err
+	}
+	unlockStack := append([][]byte{}, stack...)
+
+	stack, err = execute(stack, lock, ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(stack) == 0 || !isTrue(stack[len(stack)-1]) {
+		return fmt.Errorf("%w: stack does not end with true", ErrScriptFailed)
+	}
+
+	if !isP2SHScript(lock) {
+		return nil
+	}
+
+	// the hash matched, so the redeem script itself now has to be satisfied
+	redeemScript := unlockStack[len(unlockStack)-1]
+	stack, err = execute(unlockStack[:len(unlockStack)-1], redeemScript, ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(stack) == 0 || !isTrue(stack[len(stack)-1]) {
+		return fmt.Errorf("%w: redeem script does not end with true", ErrScriptFailed)
+	}
+
+	return nil
+}
+
+func execute(stack [][]byte, script []byte, ctx scriptContext) ([][]byte, error) {
+	if len(script) > maxScriptSize {
+		return nil, fmt.Errorf("%w: script is too large", ErrScriptFailed)
+	}
+
+	var conditions []bool
+	pop := func() ([]byte, error) {
+		if len(stack) == 0 {
+			return nil, fmt.Errorf("%w: stack is empty", ErrScriptFailed)
+		}
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return top, nil
+	}
+	popInt := func() (int64, error) {
+		data, err := pop()
+		if err != nil {
+			return 0, err
+		}
+		return decodeScriptNumber(data)
+	}
+
+	for pc := 0; pc < len(script); {
+		op, data, next, err := readOp(script, pc)
+		if err != nil {
+			return nil, err
+		}
+		pc = next
+
+		executing := true
+		for _, condition := range conditions {
+			executing = executing && condition
+		}
+
+		switch op {
+		case OpIf, OpNotIf:
+			value := false
+			if executing {
+				top, err := pop()
+				if err != nil {
+					return nil, err
+				}
+				value = isTrue(top) == (op == OpIf)
+			}
+			conditions = append(conditions, value)
+			continue
+		case OpElse:
+			if len(conditions) == 0 {
+				return nil, fmt.Errorf("%w: OpElse without OpIf", ErrScriptFailed)
+			}
+			conditions[len(conditions)-1] = !conditions[len(conditions)-1]
+			continue
+		case OpEndIf:
+			if len(conditions) == 0 {
+				return nil, fmt.Errorf("%w: OpEndIf without OpIf", ErrScriptFailed)
+			}
+			conditions = conditions[:len(conditions)-1]
+			continue
+		}
+
+		if !executing {
+			continue
+		}
+
+		switch {
+		case op <= OpPushData2:
+			stack = append(stack, data)
+		case op >= Op1 && op <= Op16:
+			stack = append(stack, encodeScriptNumber(int64(op-Op1+1)))
+		case op == OpVerify:
+			top, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			if !isTrue(top) {
+				return nil, fmt.Errorf("%w: OpVerify", ErrScriptFailed)
+			}
+		case op == OpReturn:
+			return nil, fmt.Errorf("%w: OpReturn", ErrScriptFailed)
+		case op == OpDrop:
+			if _, err := pop(); err != nil {
+				return nil, err
+			}
+		case op == OpDup:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("%w: stack is empty", ErrScriptFailed)
+			}
+			stack = append(stack, stack[len(stack)-1])
+		case op == OpSwap:
+			if len(stack) < 2 {
+				return nil, fmt.Errorf("%w: stack is too small", ErrScriptFailed)
+			}
+			stack[len(stack)-1], stack[len(stack)-2] = stack[len(stack)-2], stack[len(stack)-1]
+		case op == OpEqual, op == OpEqualVerify:
+			a, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			b, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			equal := bytes.Equal(a, b)
+			if op == OpEqualVerify {
+				if !equal {
+					return nil, fmt.Errorf("%w: OpEqualVerify", ErrScriptFailed)
+				}
+			} else {
+				stack = append(stack, scriptBool(equal))
+			}
+		case op == OpSha256:
+			top, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			hash := sha256.Sum256(top)
+			stack = append(stack, hash[:])
+		case op == OpHash160:
+			top, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, HashPubKey(top))
+		case op == OpCheckSig, op == OpCheckSigVerify:
+			publicKey, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			signature, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			valid := ctx.checkSignature(signature, publicKey)
+			if op == OpCheckSigVerify {
+				if !valid {
+					return nil, fmt.Errorf("%w: OpCheckSigVerify", ErrScriptFailed)
+				}
+			} else {
+				stack = append(stack, scriptBool(valid))
+			}
+		case op == OpCheckMultisig:
+			n, err := popInt()
+			if err != nil {
+				return nil, err
+			}
+			if n < 0 || n > 20 || int(n) > len(stack) {
+				return nil, fmt.Errorf("%w: bad public key count", ErrScriptFailed)
+			}
+			publicKeys := make([][]byte, n)
+			for i := int(n) - 1; i >= 0; i-- {
+				publicKeys[i], _ = pop()
+			}
+
+			m, err := popInt()
+			if err != nil {
+				return nil, err
+			}
+			if m < 0 || m > n || int(m) > len(stack) {
+				return nil, fmt.Errorf("%w: bad signature count", ErrScriptFailed)
+			}
+			signatures := make([][]byte, m)
+			for i := int(m) - 1; i >= 0; i-- {
+				signatures[i], _ = pop()
+			}
+
+			// signatures have to appear in the order of their public keys
+			key := 0
+			for _, signature := range signatures {
+				for key < len(publicKeys) && !ctx.checkSignature(signature, publicKeys[key]) {
+					key++
+				}
+				if key == len(publicKeys) {
+					break
+				}
+				key++
+				m--
+			}
+			stack = append(stack, scriptBool(m == 0))
+		case op == OpCheckLockTimeVerify:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("%w: stack is empty", ErrScriptFailed)
+			}
+			lockHeight, err := decodeScriptNumber(stack[len(stack)-1])
+			if err != nil {
+				return nil, err
+			}
+			if lockHeight < 0 || int64(ctx.height) < lockHeight {
+				return nil, fmt.Errorf("%w: output is locked until height %d", ErrScriptFailed, lockHeight)
+			}
+		default:
+			return nil, fmt.Errorf("%w: unknown opcode 0x%02x", ErrScriptFailed, op)
+		}
+
+		if len(stack) > maxStackSize {
+			return nil, fmt.Errorf("%w: stack overflow", ErrScriptFailed)
+		}
+	}
+
+	if len(conditions) != 0 {
+		return nil, fmt.Errorf("%w: unbalanced conditional", ErrScriptFailed)
+	}
+
+	return stack, nil
+}
+
+func scriptBool(value bool) []byte {
+	if value {
+		return []byte{1}
+	}
+
+	return []byte{}
+}
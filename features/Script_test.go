@@ -0,0 +1,101 @@
+package features
+
+import (
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+func TestScriptTemplates(t *testing.T) {
+	alice, bob, carol := NewWallet(), NewWallet(), NewWallet()
+	aliceHash, bobHash := HashPubKey(alice.PublicKey), HashPubKey(bob.PublicKey)
+
+	redeemScript := NewMultisigScript(2, [][]byte{alice.PublicKey, bob.PublicKey, carol.PublicKey})
+	multisigLock := NewP2SHScript(HashPubKey(redeemScript))
+
+	secret := []byte("the preimage of the hash lock")
+	secretHash := sha256.Sum256(secret)
+	htlcLock := NewHTLCScript(secretHash[:], aliceHash, bobHash, 10)
+
+	// spending builds a transaction spending an output locked by lock and a signer of its only input
+	spending := func(lock []byte) (*Transaction, TXOutput, func(wallet *Wallet) []byte) {
+		previousOut := *NewScriptTXOutput(10, lock)
+		transaction := &Transaction{nil, []TXInput{{make([]byte, 32), 0, nil, nil, nil}}, []TXOutput{*NewScriptTXOutput(9, []byte{Op1})}, TransactionVersion}
+		transaction.ID = transaction.Hash()
+
+		sign := func(wallet *Wallet) []byte {
+			return transaction.SignInput(0, *wallet.PrivateKey, previousOut)
+		}
+		return transaction, previousOut, sign
+	}
+
+	tests := []struct {
+		name   string
+		lock   []byte
+		height int
+		unlock func(sign func(wallet *Wallet) []byte) []byte
+		valid  bool
+	}{
+		{"p2pkh signed by the owner", NewP2PKHScript(aliceHash), 1, func(sign func(*Wallet) []byte) []byte {
+			return NewP2PKHUnlock(sign(alice), alice.PublicKey)
+		}, true},
+		{"p2pkh with the key of another", NewP2PKHScript(aliceHash), 1, func(sign func(*Wallet) []byte) []byte {
+			return NewP2PKHUnlock(sign(bob), bob.PublicKey)
+		}, false},
+		{"p2pkh signed by another", NewP2PKHScript(aliceHash), 1, func(sign func(*Wallet) []byte) []byte {
+			return NewP2PKHUnlock(sign(bob), alice.PublicKey)
+		}, false},
+		{"p2pkh without a signature", NewP2PKHScript(aliceHash), 1, func(sign func(*Wallet) []byte) []byte {
+			return NewP2PKHUnlock(nil, alice.PublicKey)
+		}, false},
+		{"multisig signed by two of three", multisigLock, 1, func(sign func(*Wallet) []byte) []byte {
+			return NewP2SHUnlock(NewMultisigUnlock([][]byte{sign(alice), sign(carol)}), redeemScript)
+		}, true},
+		{"multisig signed by one of three", multisigLock, 1, func(sign func(*Wallet) []byte) []byte {
+			return NewP2SHUnlock(NewMultisigUnlock([][]byte{sign(bob)}), redeemScript)
+		}, false},
+		{"multisig signatures out of order", multisigLock, 1, func(sign func(*Wallet) []byte) []byte {
+			return NewP2SHUnlock(NewMultisigUnlock([][]byte{sign(carol), sign(alice)}), redeemScript)
+		}, false},
+		{"multisig signed twice by one key", multisigLock, 1, func(sign func(*Wallet) []byte) []byte {
+			return NewP2SHUnlock(NewMultisigUnlock([][]byte{sign(alice), sign(alice)}), redeemScript)
+		}, false},
+		{"multisig with another redeem script", multisigLock, 1, func(sign func(*Wallet) []byte) []byte {
+			other := NewMultisigScript(1, [][]byte{alice.PublicKey})
+			return NewP2SHUnlock(NewMultisigUnlock([][]byte{sign(alice)}), other)
+		}, false},
+		{"htlc claimed with the secret", htlcLock, 1, func(sign func(*Wallet) []byte) []byte {
+			return NewHTLCClaim(sign(alice), alice.PublicKey, secret)
+		}, true},
+		{"htlc claimed with a wrong secret", htlcLock, 1, func(sign func(*Wallet) []byte) []byte {
+			return NewHTLCClaim(sign(alice), alice.PublicKey, []byte("a guess"))
+		}, false},
+		{"htlc claimed by the sender", htlcLock, 1, func(sign func(*Wallet) []byte) []byte {
+			return NewHTLCClaim(sign(bob), bob.PublicKey, secret)
+		}, false},
+		{"htlc refunded before the lock height", htlcLock, 9, func(sign func(*Wallet) []byte) []byte {
+			return NewHTLCRefund(sign(bob), bob.PublicKey)
+		}, false},
+		{"htlc refunded at the lock height", htlcLock, 10, func(sign func(*Wallet) []byte) []byte {
+			return NewHTLCRefund(sign(bob), bob.PublicKey)
+		}, true},
+		{"htlc refunded to the recipient", htlcLock, 10, func(sign func(*Wallet) []byte) []byte {
+			return NewHTLCRefund(sign(alice), alice.PublicKey)
+		}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			transaction, previousOut, sign := spending(test.lock)
+			ctx := scriptContext{transaction, 0, previousOut, test.height}
+
+			err := evalScript(test.unlock(sign), previousOut.LockingScript(), ctx)
+			if test.valid && err != nil {
+				t.Fatalf("the script failed: %s", err)
+			}
+			if !test.valid && !errors.Is(err, ErrScriptFailed) {
+				t.Fatalf("the script returned %v instead of failing", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package features
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/boltdb/bolt"
+)
+
+// maps the ID of every transaction on the main chain to the hash of its block
+// followed by its position in the block as 4 bytes
+const txIndexBucket = "txindex"
+
+// lookupTransaction returns a transaction of the main chain with a single index read
+func lookupTransaction(tx *bolt.Tx, Id []byte) (Transaction, error) {
+	index := tx.Bucket([]byte(txIndexBucket))
+	if index == nil {
+		return Transaction{}, errors.New("Transaction index is not found, the database needs a migration !!!")
+	}
+
+	location := index.Get(Id)
+	if len(location) < 4 {
+		return Transaction{}, errors.New("Transaction is not found !!!")
+	}
+
+	blockHash := location[:len(location)-4]
+	position := int(binary.BigEndian.Uint32(location[len(location)-4:]))
+
+	blockData := tx.Bucket([]byte(blocksBucket)).Get(blockHash)
+	if blockData == nil {
+		return Transaction{}, errors.New("Block of the transaction is not found !!!")
+	}
+
+	block := DeserializeBlock(blockData)
+	if position >= len(block.Transactions) {
+		return Transaction{}, errors.New("Transaction index is corrupted !!!")
+	}
+
+	return *block.Transactions[position], nil
+}
+
+// indexTransactions records where the transactions of a connected block are
+func indexTransactions(tx *bolt.Tx, block *Block) error {
+	index, err := tx.CreateBucketIfNotExists([]byte(txIndexBucket))
+	if err != nil {
+		return err
+	}
+
+	for position, transaction := range block.Transactions {
+		location := make([]byte, 4)
+		binary.BigEndian.PutUint32(location, uint32(position))
+
+		err := index.Put(transaction.ID, append(append([]byte{}, block.Hash...), location...))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unindexTransactions forgets the transactions of a disconnected block
+func unindexTransactions(tx *bolt.Tx, block *Block) error {
+	index := tx.Bucket([]byte(txIndexBucket))
+	if index == nil {
+		return nil
+	}
+
+	for _, transaction := range block.Transactions {
+		err := index.Delete(transaction.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -2,11 +2,23 @@ package features
 
 import "bytes"
 
+// TXInput spends output Value of transaction TXid. Pay-to-pubkey-hash outputs are
+// unlocked with Signature and PublicKey, any other script with ScriptSig.
 type TXInput struct {
 	TXid      []byte
 	Value     int
 	Signature []byte
 	PublicKey []byte
+	ScriptSig []byte
+}
+
+// UnlockingScript returns the script evaluated before the locking script of the spent output
+func (in *TXInput) UnlockingScript() []byte {
+	if len(in.ScriptSig) > 0 {
+		return in.ScriptSig
+	}
+
+	return NewP2PKHUnlock(in.Signature, in.PublicKey)
 }
 
 func (in *TXInput) isValidTX(publicKey []byte) bool {
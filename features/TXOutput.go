@@ -3,53 +3,99 @@ package features
 import (
 	"COMP5567-BlockChain/utils"
 	"bytes"
-	"encoding/gob"
 	"log"
 )
 
+// TXOutput is locked to PubKeyHash unless it carries its own locking Script
 type TXOutput struct {
 	Value      int
 	PubKeyHash []byte
+	Script     []byte
 }
 
+// LockingScript returns the script an input has to satisfy to spend the output
+func (out *TXOutput) LockingScript() []byte {
+	if len(out.Script) > 0 {
+		return out.Script
+	}
+
+	return NewP2PKHScript(out.PubKeyHash)
+}
+
+// Lock locks the output to an address, multisig addresses lock it to the hash of
+// their redeem script, which is also kept in PubKeyHash so the output can be looked up
 func (out *TXOutput) Lock(address []byte) {
 	pubKeyHash := utils.Base64Decode(address)
+	addressVersion := pubKeyHash[0]
 	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
 	out.PubKeyHash = pubKeyHash
+
+	if addressVersion == scriptVersion {
+		out.Script = NewP2SHScript(pubKeyHash)
+	}
 }
 
-// to check the output whether can be used by the public key owner
+// to check the output whether can be used by the public key owner, or by the signers of the
+// multisig redeem script hashing to pubKeyHash. The locking script decides, not PubKeyHash,
+// which anyone can set on an output locked by some other script.
 func (out *TXOutput) IsLockedWithKey(pubKeyHash []byte) bool {
-	return bytes.Compare(out.PubKeyHash, pubKeyHash) == 0
+	script := out.LockingScript()
+
+	return bytes.Equal(script, NewP2PKHScript(pubKeyHash)) || bytes.Equal(script, NewP2SHScript(pubKeyHash))
+}
+
+// Address returns the address the output pays to, or nil when it is locked by another script
+func (out *TXOutput) Address() []byte {
+	script := out.LockingScript()
+	if bytes.Equal(script, NewP2PKHScript(out.PubKeyHash)) {
+		return encodeAddress(version, out.PubKeyHash)
+	}
+	if bytes.Equal(script, NewP2SHScript(out.PubKeyHash)) {
+		return encodeAddress(scriptVersion, out.PubKeyHash)
+	}
+
+	return nil
 }
 
 func NewTXOutput(value int, address string) *TXOutput {
-	txOutput := &TXOutput{value, nil}
+	txOutput := &TXOutput{value, nil, nil}
 	txOutput.Lock([]byte(address))
 
 	return txOutput
 }
 
+// NewScriptTXOutput creates an output locked by a custom script such as a multisig or hash-timelock template
+func NewScriptTXOutput(value int, script []byte) *TXOutput {
+	return &TXOutput{value, nil, script}
+}
+
+// TXOutputs holds the unspent outputs of one transaction keyed by their index in it,
+// along with the height of the block that created them and whether it was a coinbase
 type TXOutputs struct {
-	Outputs []TXOutput
+	Outputs    map[int]TXOutput
+	Height     int
+	IsCoinbase bool
 }
 
-func (outputs TXOutputs) Serialize() []byte {
-	var buff bytes.Buffer
-	encryption := gob.NewEncoder(&buff)
-	err := encryption.Encode(outputs)
-	if err != nil {
-		log.Panic("Serialization Error!!!!", err)
+// IsMature reports whether the outputs may be spent by a block at height
+func (outputs TXOutputs) IsMature(height int) bool {
+	if !outputs.IsCoinbase || outputs.Height == 0 {
+		return true
 	}
 
-	return buff.Bytes()
+	return height-outputs.Height >= CoinbaseMaturity
 }
 
-func DeserializeOutputs(data []byte) TXOutputs {
-	var outputs TXOutputs
+// Serialize returns the canonical encoding of the outputs, see Encoding.go
+func (outputs TXOutputs) Serialize() []byte {
+	var e encoder
+	outputs.encode(&e)
 
-	decode := gob.NewDecoder(bytes.NewReader(data))
-	err := decode.Decode(&outputs)
+	return e.Bytes()
+}
+
+func DeserializeOutputs(data []byte) TXOutputs {
+	outputs, err := decodeOutputs(data)
 	if err != nil {
 		log.Panic("Serialization Decoding Error!!!", err)
 	}
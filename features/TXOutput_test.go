@@ -0,0 +1,70 @@
+package features
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestIsLockedWithKey(t *testing.T) {
+	owner := NewWallet()
+	other := NewWallet()
+	ownerHash := HashPubKey(owner.PublicKey)
+	otherHash := HashPubKey(other.PublicKey)
+
+	multisig, err := NewMultisigWallet(2, [][]byte{owner.PublicKey, other.PublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scriptHash := HashPubKey(multisig.RedeemScript)
+
+	tests := []struct {
+		name   string
+		out    TXOutput
+		hash   []byte
+		locked bool
+	}{
+		{"pay to the key", *NewTXOutput(1, walletAddress(owner)), ownerHash, true},
+		{"pay to another key", *NewTXOutput(1, walletAddress(other)), ownerHash, false},
+		{"pay to the multisig", *NewTXOutput(1, fmt.Sprintf("%s", multisig.GetAddress())), scriptHash, true},
+		{"baseline output", TXOutput{1, ownerHash, nil}, ownerHash, true},
+		{"hash of the key on a script of another", TXOutput{1, ownerHash, NewP2PKHScript(otherHash)}, ownerHash, false},
+		{"hash of the key on an unspendable script", TXOutput{1, ownerHash, []byte{OpReturn}}, ownerHash, false},
+		{"hash of the key on a hash-timelock", TXOutput{1, ownerHash, NewHTLCScript(make([]byte, 32), ownerHash, otherHash, 10)}, ownerHash, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if locked := test.out.IsLockedWithKey(test.hash); locked != test.locked {
+				t.Fatalf("IsLockedWithKey returned %v", locked)
+			}
+		})
+	}
+}
+
+func TestAddress(t *testing.T) {
+	owner := NewWallet()
+	multisig, err := NewMultisigWallet(1, [][]byte{owner.PublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownerHash := HashPubKey(owner.PublicKey)
+
+	tests := []struct {
+		name    string
+		out     TXOutput
+		address string
+	}{
+		{"pay to the key", *NewTXOutput(1, walletAddress(owner)), walletAddress(owner)},
+		{"pay to the multisig", *NewTXOutput(1, fmt.Sprintf("%s", multisig.GetAddress())), fmt.Sprintf("%s", multisig.GetAddress())},
+		{"baseline output", TXOutput{1, ownerHash, nil}, walletAddress(owner)},
+		{"hash-timelock", *NewScriptTXOutput(1, NewHTLCScript(make([]byte, 32), ownerHash, ownerHash, 10)), ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if address := string(test.out.Address()); address != test.address {
+				t.Fatalf("Address returned %q instead of %q", address, test.address)
+			}
+		})
+	}
+}
@@ -1,12 +1,10 @@
 package features
 
 import (
-	"bytes"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
-	"encoding/gob"
 	"encoding/hex"
 	"fmt"
 	"log"
@@ -14,12 +12,11 @@ import (
 	"strings"
 )
 
-const subsidy = 10
-
 type Transaction struct {
 	ID        []byte     `json:"ID"`
 	TXInputs  []TXInput  `json:"TXInputs"`
 	TXOutputs []TXOutput `json:"TXOutputs"`
+	Version   int        `json:"Version"`
 }
 
 // check whether this transaction is coinbase
@@ -27,26 +24,38 @@ func (transation Transaction) IsCionBase() bool {
 	return len(transation.TXInputs) == 1 && len(transation.TXInputs[0].TXid) == 0 && transation.TXInputs[0].Value == -1
 }
 
+// Serialize returns the canonical encoding of the transaction, see Encoding.go
 func (transaction Transaction) Serialize() []byte {
-	var encode bytes.Buffer
+	var e encoder
+	transaction.encode(&e)
 
-	encryption := gob.NewEncoder(&encode)
-	err := encryption.Encode(transaction)
-	if err != nil {
-		log.Panic("Transaction Serialization Failed!!!", err)
-	}
-	return encode.Bytes()
+	return e.Bytes()
 }
 
+// Hash returns the SHA-256 of the encoding, which leaves out the ID. Migrated
+// transactions were hashed over gob, so their stored ID is returned instead.
 func (transaction *Transaction) Hash() []byte {
-	var hash [32]byte
+	if transaction.Version == legacyVersion {
+		return append([]byte{}, transaction.ID...)
+	}
+
+	hash := sha256.Sum256(transaction.Serialize())
 
+	return hash[:]
+}
+
+// UnsignedHash recomputes the ID, which is taken before the inputs are signed or unlocked
+func (transaction *Transaction) UnsignedHash() []byte {
 	txCopy := *transaction
-	txCopy.ID = []byte{}
+	txCopy.TXInputs = make([]TXInput, len(transaction.TXInputs))
 
-	hash = sha256.Sum256(txCopy.Serialize())
+	for id, in := range transaction.TXInputs {
+		txCopy.TXInputs[id] = in
+		txCopy.TXInputs[id].Signature = nil
+		txCopy.TXInputs[id].ScriptSig = nil
+	}
 
-	return hash[:]
+	return txCopy.Hash()
 }
 
 func (transaction *Transaction) ModifiedCopy() Transaction {
@@ -54,17 +63,107 @@ func (transaction *Transaction) ModifiedCopy() Transaction {
 	var outputs []TXOutput
 
 	for _, in := range transaction.TXInputs {
-		inputs = append(inputs, TXInput{in.TXid, in.Value, nil, nil})
+		inputs = append(inputs, TXInput{in.TXid, in.Value, nil, nil, nil})
 	}
 
 	for _, out := range transaction.TXOutputs {
-		outputs = append(outputs, TXOutput{out.Value, out.PubKeyHash})
+		outputs = append(outputs, TXOutput{out.Value, out.PubKeyHash, out.Script})
 	}
 
-	txCopy := Transaction{transaction.ID, inputs, outputs}
+	txCopy := Transaction{transaction.ID, inputs, outputs, transaction.Version}
 	return txCopy
 }
 
+// sigHash is the digest signed for one input, it commits to the whole transaction
+// without any unlocking data plus the locking script of the output being spent
+func (transaction *Transaction) sigHash(input int, previousOut TXOutput) []byte {
+	if transaction.Version == legacyVersion {
+		return transaction.legacySigHash(input, previousOut)
+	}
+
+	txCopy := transaction.ModifiedCopy()
+	txCopy.TXInputs[input].PublicKey = previousOut.LockingScript()
+
+	hash := sha256.Sum256(txCopy.Serialize())
+	return hash[:]
+}
+
+// legacySigHash is what migrated transactions were signed over: the transaction as the old
+// structs printed with %x, the input being signed carrying the PubKeyHash of the output it
+// spends. It was signed as is, ECDSA keeping the first 32 bytes of it.
+func (transaction *Transaction) legacySigHash(input int, previousOut TXOutput) []byte {
+	type legacyInput struct {
+		TXid      []byte
+		Value     int
+		Signature []byte
+		PublicKey []byte
+	}
+	type legacyOutput struct {
+		Value      int
+		PubKeyHash []byte
+	}
+
+	var inputs []legacyInput
+	var outputs []legacyOutput
+	for _, in := range transaction.TXInputs {
+		inputs = append(inputs, legacyInput{in.TXid, in.Value, nil, nil})
+	}
+	for _, out := range transaction.TXOutputs {
+		outputs = append(outputs, legacyOutput{out.Value, out.PubKeyHash})
+	}
+	inputs[input].PublicKey = previousOut.PubKeyHash
+
+	txCopy := struct {
+		ID        []byte
+		TXInputs  []legacyInput
+		TXOutputs []legacyOutput
+	}{transaction.ID, inputs, outputs}
+
+	return []byte(fmt.Sprintf("%x\n", txCopy))
+}
+
+// SignInput returns the signature of the key over the input spending previousOut
+func (transaction *Transaction) SignInput(input int, privateKey ecdsa.PrivateKey, previousOut TXOutput) []byte {
+	r, s, err := ecdsa.Sign(rand.Reader, &privateKey, transaction.sigHash(input, previousOut))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	// both halves are padded so the signature can be split in the middle
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	return signature
+}
+
+func verifySignature(hash, signature, publicKey []byte) bool {
+	if len(signature) == 0 || len(publicKey) == 0 {
+		return false
+	}
+
+	r := big.Int{}
+	s := big.Int{}
+	sigLen := len(signature)
+	r.SetBytes(signature[:(sigLen / 2)])
+	s.SetBytes(signature[(sigLen / 2):])
+
+	x := big.Int{}
+	y := big.Int{}
+	keyLen := len(publicKey)
+	x.SetBytes(publicKey[:(keyLen / 2)])
+	y.SetBytes(publicKey[(keyLen / 2):])
+
+	curve := elliptic.P256()
+	if !curve.IsOnCurve(&x, &y) {
+		return false
+	}
+
+	rawPublicKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
+	return ecdsa.Verify(&rawPublicKey, hash, &r, &s)
+}
+
+// Sign signs every input with the key, assuming they all spend pay-to-pubkey-hash outputs of it
 func (transaction *Transaction) Sign(privateKey ecdsa.PrivateKey, previousTXs map[string]Transaction) {
 	if transaction.IsCionBase() {
 		return
@@ -76,23 +175,9 @@ func (transaction *Transaction) Sign(privateKey ecdsa.PrivateKey, previousTXs ma
 		}
 	}
 
-	txCopy := transaction.ModifiedCopy()
-
-	for id, in := range txCopy.TXInputs {
+	for id, in := range transaction.TXInputs {
 		previousTX := previousTXs[hex.EncodeToString(in.TXid)]
-		txCopy.TXInputs[id].Signature = nil
-		txCopy.TXInputs[id].PublicKey = previousTX.TXOutputs[in.Value].PubKeyHash
-
-		dataToSign := fmt.Sprintf("%x\n", txCopy)
-
-		r, s, err := ecdsa.Sign(rand.Reader, &privateKey, []byte(dataToSign))
-		if err != nil {
-			log.Panic(err)
-		}
-		signature := append(r.Bytes(), s.Bytes()...)
-
-		transaction.TXInputs[id].Signature = signature
-		txCopy.TXInputs[id].PublicKey = nil
+		transaction.TXInputs[id].Signature = transaction.SignInput(id, privateKey, previousTX.TXOutputs[in.Value])
 	}
 }
 
@@ -106,18 +191,25 @@ func (transaction *Transaction) String() string {
 		lines = append(lines, fmt.Sprintf("		Value Output: 	%d", input.Value))
 		lines = append(lines, fmt.Sprintf("		Signature: 		%x", input.Signature))
 		lines = append(lines, fmt.Sprintf("		PubKey:			%x", input.PublicKey))
+		if len(input.ScriptSig) > 0 {
+			lines = append(lines, fmt.Sprintf("		ScriptSig:		%x", input.ScriptSig))
+		}
 	}
 
 	for i, output := range transaction.TXOutputs {
 		lines = append(lines, fmt.Sprintf("		Output			%d:", i))
 		lines = append(lines, fmt.Sprintf("		Value:			%d:", output.Value))
 		lines = append(lines, fmt.Sprintf("		PublicKeyHash:	%x:", output.PubKeyHash))
+		if len(output.Script) > 0 {
+			lines = append(lines, fmt.Sprintf("		Script:			%x", output.Script))
+		}
 	}
 	return strings.Join(lines, "\n")
 }
 
-// verify the signature of Transaction Inputs
-func (transaction *Transaction) Verify(previousTXs map[string]Transaction) bool {
+// Verify runs the unlocking script of every input against the locking script
+// of the output it spends, height is the height of the block including it
+func (transaction *Transaction) Verify(previousTXs map[string]Transaction, height int) bool {
 	if transaction.IsCionBase() {
 		return true
 	}
@@ -128,38 +220,20 @@ func (transaction *Transaction) Verify(previousTXs map[string]Transaction) bool
 		}
 	}
 
-	txCopy := transaction.ModifiedCopy()
-	curve := elliptic.P256()
-
 	for id, in := range transaction.TXInputs {
 		previousTX := previousTXs[hex.EncodeToString(in.TXid)]
-		txCopy.TXInputs[id].Signature = nil
-		txCopy.TXInputs[id].PublicKey = previousTX.TXOutputs[in.Value].PubKeyHash
-
-		r := big.Int{}
-		s := big.Int{}
-		sigLen := len(in.Signature)
-		r.SetBytes(in.Signature[:(sigLen / 2)])
-		s.SetBytes(in.Signature[(sigLen / 2):])
+		previousOut := previousTX.TXOutputs[in.Value]
 
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(in.PublicKey)
-		x.SetBytes(in.PublicKey[:(keyLen / 2)])
-		y.SetBytes(in.PublicKey[(keyLen / 2):])
-
-		dataToVerify := fmt.Sprintf("%x\n", txCopy)
-
-		rawPublicKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
-		if ecdsa.Verify(&rawPublicKey, []byte(dataToVerify), &r, &s) == false {
+		ctx := scriptContext{transaction, id, previousOut, height}
+		if evalScript(in.UnlockingScript(), previousOut.LockingScript(), ctx) != nil {
 			return false
 		}
-		txCopy.TXInputs[id].PublicKey = nil
 	}
 	return true
 }
 
-func NewCoinbaseTX(to, data string) *Transaction {
+// NewCoinbaseTX creates the transaction paying the subsidy of the block at height plus the fees collected from its transactions
+func NewCoinbaseTX(to, data string, height, fees int) *Transaction {
 	if data == "" {
 		randData := make([]byte, 20)
 		_, err := rand.Read(randData)
@@ -170,19 +244,21 @@ func NewCoinbaseTX(to, data string) *Transaction {
 		data = fmt.Sprintf("%x", randData)
 	}
 
-	txInput := TXInput{[]byte{}, -1, nil, []byte(data)}
-	txOutput := NewTXOutput(subsidy, to)
-	transaction := Transaction{nil, []TXInput{txInput}, []TXOutput{*txOutput}}
+	txInput := TXInput{[]byte{}, -1, nil, []byte(data), nil}
+	txOutput := NewTXOutput(Emission.Subsidy(height)+fees, to)
+	transaction := Transaction{nil, []TXInput{txInput}, []TXOutput{*txOutput}, TransactionVersion}
 	transaction.ID = transaction.Hash()
 
 	return &transaction
 }
 
-func DeserializeTransaction(data []byte) Transaction {
-	var transaction Transaction
+// DecodeTransaction is DeserializeTransaction for data from untrusted sources, it returns the decoding error
+func DecodeTransaction(data []byte) (Transaction, error) {
+	return decodeTransaction(data)
+}
 
-	decode := gob.NewDecoder(bytes.NewReader(data))
-	err := decode.Decode(&transaction)
+func DeserializeTransaction(data []byte) Transaction {
+	transaction, err := decodeTransaction(data)
 	if err != nil {
 		log.Panic(err)
 	}
@@ -190,14 +266,16 @@ func DeserializeTransaction(data []byte) Transaction {
 	return transaction
 }
 
-func NewUTXOTransaction(wallet *Wallet, to string, amount int, UTXOSet *UTXOSet) *Transaction {
+// NewUTXOTransaction sends amount to the address and leaves fee to the miner,
+// the fee is implicit as the difference between the inputs and the outputs
+func NewUTXOTransaction(wallet *Wallet, to string, amount, fee int, UTXOSet *UTXOSet) *Transaction {
 	var inputs []TXInput
 	var outputs []TXOutput
 
 	pubKeyHash := HashPubKey(wallet.PublicKey)
-	acc, validOutputs := UTXOSet.FindSpendableOutputs(pubKeyHash, amount)
+	acc, validOutputs := UTXOSet.FindSpendableOutputs(pubKeyHash, amount+fee)
 
-	if acc < amount {
+	if acc < amount+fee {
 		log.Panic("ERROR: Not enough funds")
 	}
 
@@ -208,20 +286,23 @@ func NewUTXOTransaction(wallet *Wallet, to string, amount int, UTXOSet *UTXOSet)
 		}
 
 		for _, out := range outs {
-			input := TXInput{txID, out, nil, wallet.PublicKey}
+			input := TXInput{txID, out, nil, wallet.PublicKey, nil}
 			inputs = append(inputs, input)
 		}
 	}
 
 	from := fmt.Sprintf("%s", wallet.GetAddress())
 	outputs = append(outputs, *NewTXOutput(amount, to))
-	if acc < amount {
-		outputs = append(outputs, *NewTXOutput(acc-amount, from))
+	if acc > amount+fee {
+		outputs = append(outputs, *NewTXOutput(acc-amount-fee, from))
 	}
 
-	transaction := Transaction{nil, inputs, outputs}
+	transaction := Transaction{nil, inputs, outputs, TransactionVersion}
 	transaction.ID = transaction.Hash()
-	UTXOSet.BlockChain.SignTransaction(&transaction, *wallet.PrivateKey)
+	err := UTXOSet.BlockChain.SignTransaction(&transaction, *wallet.PrivateKey)
+	if err != nil {
+		log.Panic(err)
+	}
 
 	return &transaction
 }
@@ -0,0 +1,52 @@
+package features
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"io/ioutil"
+	"testing"
+)
+
+// loadLegacyTransactions reads transactions the baseline signed and gob-encoded: a coinbase,
+// a transaction splitting it in two outputs and one spending both of them
+func loadLegacyTransactions(t *testing.T) []Transaction {
+	t.Helper()
+
+	data, err := ioutil.ReadFile("testdata/legacy_transactions.gob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var transactions []Transaction
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&transactions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range transactions {
+		transactions[i].Version = legacyVersion
+	}
+
+	return transactions
+}
+
+func TestLegacySignaturesVerify(t *testing.T) {
+	transactions := loadLegacyTransactions(t)
+
+	for i := 1; i < len(transactions); i++ {
+		previous := transactions[i-1]
+		previousTXs := map[string]Transaction{hex.EncodeToString(previous.ID): previous}
+
+		if !transactions[i].Verify(previousTXs, 1) {
+			t.Fatalf("transaction %d signed by the baseline does not verify", i)
+		}
+
+		// the signed preimage was truncated to 32 bytes, which all come from the ID
+		tampered := transactions[i]
+		tampered.ID = append([]byte{}, tampered.ID...)
+		tampered.ID[0] ^= 0xff
+		if tampered.Verify(previousTXs, 1) {
+			t.Fatalf("transaction %d verifies with a tampered ID", i)
+		}
+	}
+}
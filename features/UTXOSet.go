@@ -2,28 +2,84 @@ package features
 
 import (
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"github.com/boltdb/bolt"
 	"log"
 )
 
 const UTXOBucket = "chainstate"
 
+// defaultCoinbaseMaturity is the maturity of chains created before it was stored
+const defaultCoinbaseMaturity = 10
+
+// CoinbaseMaturity is the number of blocks a coinbase output has to wait before it can
+// be spent. The genesis coinbase is exempt so that a new chain has funds to start with.
+// Like the emission schedule a new chain takes it from config.yaml and stores it under
+// maturityKey, and opening a chain reads it back.
+var CoinbaseMaturity = defaultCoinbaseMaturity
+
+var maturityKey = []byte("coinbaseMaturity")
+
+// putCoinbaseMaturity stores the maturity a chain is created with
+func putCoinbaseMaturity(tx *bolt.Tx, maturity int) error {
+	meta, err := tx.CreateBucketIfNotExists([]byte(metaBucket))
+	if err != nil {
+		return err
+	}
+
+	var e encoder
+	e.writeVarint(int64(maturity))
+
+	return meta.Put(maturityKey, e.Bytes())
+}
+
+// loadCoinbaseMaturity makes the maturity stored with the chain the one in use
+func loadCoinbaseMaturity(tx *bolt.Tx) error {
+	data := tx.Bucket([]byte(metaBucket)).Get(maturityKey)
+	if data == nil {
+		return errors.New("The coinbase maturity of the chain is not stored !!!")
+	}
+
+	d := newDecoder(data)
+	maturity := int(d.readVarint())
+	err := d.finish()
+	if err != nil {
+		return err
+	}
+
+	CoinbaseMaturity = maturity
+	return nil
+}
+
+// storeDefaultCoinbaseMaturity stores the maturity of a chain created before it was stored
+func storeDefaultCoinbaseMaturity(tx *bolt.Tx) error {
+	return putCoinbaseMaturity(tx, defaultCoinbaseMaturity)
+}
+
 type UTXOSet struct {
 	BlockChain *BlockChain
 }
 
+// FindSpendableOutputs collects outputs locked with the key worth at least amount, skipping immature coinbase outputs
 func (utxo UTXOSet) FindSpendableOutputs(publicKeyHash []byte, amount int) (int, map[string][]int) {
 	unspentOutputs := make(map[string][]int)
 	accumulated := 0
 	db := utxo.BlockChain.DB
 
 	err := db.View(func(tx *bolt.Tx) error {
+		blocks := tx.Bucket([]byte(blocksBucket))
+		height := DeserializeBlock(blocks.Get(blocks.Get([]byte("l")))).Height + 1
+
 		b := tx.Bucket([]byte(UTXOBucket))
 		c := b.Cursor()
 
 		for k, v := c.First(); k != nil; k, v = c.Next() {
 			txID := hex.EncodeToString(k)
 			outs := DeserializeOutputs(v)
+			if !outs.IsMature(height) {
+				continue
+			}
 
 			for outIdx, out := range outs.Outputs {
 				if out.IsLockedWithKey(publicKeyHash) && accumulated < amount {
@@ -66,6 +122,121 @@ func (utxo UTXOSet) FindUTXO(publicKeyHash []byte) []TXOutput {
 	return UTXOs
 }
 
+// PubKeyHashes returns the hex encoded hashes that own at least one unspent output
+func (utxo UTXOSet) PubKeyHashes() map[string]bool {
+	hashes := make(map[string]bool)
+	db := utxo.BlockChain.DB
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(UTXOBucket))
+		c := b.Cursor()
+
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			outs := DeserializeOutputs(v)
+
+			for _, out := range outs.Outputs {
+				hashes[hex.EncodeToString(out.PubKeyHash)] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return hashes
+}
+
+// Fee returns the inputs of the transaction minus its outputs, every input must be in the UTXO set
+func (utxo UTXOSet) Fee(transaction *Transaction) (int, error) {
+	if transaction.IsCionBase() {
+		return 0, nil
+	}
+
+	fee := 0
+	db := utxo.BlockChain.DB
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(UTXOBucket))
+
+		for _, in := range transaction.TXInputs {
+			outsBytes := b.Get(in.TXid)
+			if outsBytes == nil {
+				return fmt.Errorf("Output %x:%d is not found in the UTXO set !!!", in.TXid, in.Value)
+			}
+
+			out, ok := DeserializeOutputs(outsBytes).Outputs[in.Value]
+			if !ok {
+				return fmt.Errorf("Output %x:%d is not found in the UTXO set !!!", in.TXid, in.Value)
+			}
+			fee, ok = addMoney(fee, out.Value)
+			if !ok {
+				return fmt.Errorf("%w: %x spends more than %d", ErrBadTransaction, transaction.ID, MaxMoney)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	outputs, err := outputTotal(transaction)
+	if err != nil {
+		return 0, err
+	}
+	return fee - outputs, nil
+}
+
+// IsMature reports whether every input of the transaction may be spent by a block at height
+func (utxo UTXOSet) IsMature(transaction *Transaction, height int) bool {
+	if transaction.IsCionBase() {
+		return true
+	}
+
+	mature := true
+	db := utxo.BlockChain.DB
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(UTXOBucket))
+
+		for _, in := range transaction.TXInputs {
+			outsBytes := b.Get(in.TXid)
+			if outsBytes != nil && !DeserializeOutputs(outsBytes).IsMature(height) {
+				mature = false
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return mature
+}
+
+// Supply returns the coins held by all unspent outputs
+func (utxo UTXOSet) Supply() int {
+	db := utxo.BlockChain.DB
+	supply := 0
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(UTXOBucket))
+		c := b.Cursor()
+
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			for _, out := range DeserializeOutputs(v).Outputs {
+				supply += out.Value
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return supply
+}
+
 func (utxo UTXOSet) CountTransactions() int {
 	db := utxo.BlockChain.DB
 	counter := 0
@@ -88,92 +259,186 @@ func (utxo UTXOSet) CountTransactions() int {
 	return counter
 }
 
+// Reindex rebuilds the chainstate, the undo records, the transaction and height indexes
+// and, when it is enabled, the address index by replaying the main chain from genesis
 func (utxo UTXOSet) Reindex() {
 	db := utxo.BlockChain.DB
-	bucketName := []byte(UTXOBucket)
 
-	err := db.Update(func(tx *bolt.Tx) error {
-		err := tx.DeleteBucket(bucketName)
+	err := db.Update(reindexChainstate)
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// reindexChainstate rebuilds the UTXO set, the undo records and the indexes from the main chain
+func reindexChainstate(tx *bolt.Tx) error {
+	bucketNames := []string{UTXOBucket, undoBucket, txIndexBucket, heightIndexBucket}
+	if tx.Bucket([]byte(addressIndexBucket)) != nil {
+		bucketNames = append(bucketNames, addressIndexBucket)
+	}
+
+	for _, bucketName := range bucketNames {
+		err := tx.DeleteBucket([]byte(bucketName))
 		if err != nil && err != bolt.ErrBucketNotFound {
-			log.Panic(err)
+			return err
 		}
 
-		_, err = tx.CreateBucket(bucketName)
+		_, err = tx.CreateBucket([]byte(bucketName))
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
-		return nil
-	})
+	}
 
-	if err != nil {
-		log.Panic(err)
+	var chain []*Block
+	b := tx.Bucket([]byte(blocksBucket))
+	hash := b.Get([]byte("l"))
+
+	for len(hash) != 0 {
+		block := DeserializeBlock(b.Get(hash))
+		chain = append(chain, block)
+		hash = block.PreviousHash
 	}
 
-	UTXO := utxo.BlockChain.FindUTXO()
+	for i := len(chain) - 1; i >= 0; i-- {
+		err := connectBlock(tx, chain[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	err = db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bucketName)
+// connectBlock spends the inputs of every transaction in the block, adds their
+// outputs to the chainstate, records the spent outputs in the undo bucket and indexes the block
+func connectBlock(tx *bolt.Tx, block *Block) error {
+	b := tx.Bucket([]byte(UTXOBucket))
+	undo := BlockUndo{}
+
+	for _, transaction := range block.Transactions {
+		if transaction.IsCionBase() == false {
+			for _, in := range transaction.TXInputs {
+				outsBytes := b.Get(in.TXid)
+				if outsBytes == nil {
+					return fmt.Errorf("Output %x:%d is not found in the UTXO set !!!", in.TXid, in.Value)
+				}
 
-		for txID, outs := range UTXO {
-			key, err := hex.DecodeString(txID)
-			if err != nil {
-				log.Panic(err)
-			}
+				outs := DeserializeOutputs(outsBytes)
+				out, ok := outs.Outputs[in.Value]
+				if !ok {
+					return fmt.Errorf("Output %x:%d is not found in the UTXO set !!!", in.TXid, in.Value)
+				}
+				undo.Spent = append(undo.Spent, SpentOutput{in.TXid, in.Value, out, outs.Height, outs.IsCoinbase})
+				delete(outs.Outputs, in.Value)
 
-			err = b.Put(key, outs.Serialize())
-			if err != nil {
-				log.Panic(err)
+				if len(outs.Outputs) == 0 {
+					err := b.Delete(in.TXid)
+					if err != nil {
+						return err
+					}
+				} else {
+					err := b.Put(in.TXid, outs.Serialize())
+					if err != nil {
+						return err
+					}
+				}
 			}
 		}
-		return nil
-	})
+
+		nOutputs := TXOutputs{make(map[int]TXOutput), block.Height, transaction.IsCionBase()}
+		for outIdx, out := range transaction.TXOutputs {
+			nOutputs.Outputs[outIdx] = out
+		}
+
+		err := b.Put(transaction.ID, nOutputs.Serialize())
+		if err != nil {
+			return err
+		}
+	}
+
+	undos, err := tx.CreateBucketIfNotExists([]byte(undoBucket))
+	if err != nil {
+		return err
+	}
+	err = undos.Put(block.Hash, undo.Serialize())
+	if err != nil {
+		return err
+	}
+
+	err = indexTransactions(tx, block)
+	if err != nil {
+		return err
+	}
+
+	err = indexHeight(tx, block)
+	if err != nil {
+		return err
+	}
+
+	return indexBlock(tx, block, undo)
 }
 
-func (utxo UTXOSet) Update(block *Block) {
-	db := utxo.BlockChain.DB
+// disconnectBlock reverts connectBlock: the outputs created by the block are
+// removed and the outputs it spent are restored from its undo record
+func disconnectBlock(tx *bolt.Tx, block *Block) error {
+	b := tx.Bucket([]byte(UTXOBucket))
+	undos := tx.Bucket([]byte(undoBucket))
 
-	err := db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(UTXOBucket))
+	var undoData []byte
+	if undos != nil {
+		undoData = undos.Get(block.Hash)
+	}
+	if undoData == nil {
+		return fmt.Errorf("Undo data of block %x is not found, the UTXO set needs a reindex !!!", block.Hash)
+	}
+	undo := DeserializeUndo(undoData)
+	spent := undo.Spent
 
-		for _, tx := range block.Transactions {
-			if tx.IsCionBase() == false {
-				for _, in := range tx.TXInputs {
-					updatedOuts := TXOutputs{}
-					outsBytes := b.Get(in.TXid)
-					outs := DeserializeOutputs(outsBytes)
-
-					for outIdx, out := range outs.Outputs {
-						if outIdx != in.Value {
-							updatedOuts.Outputs = append(updatedOuts.Outputs, out)
-						}
-					}
+	err := unindexBlock(tx, block, undo)
+	if err != nil {
+		return err
+	}
 
-					if len(updatedOuts.Outputs) == 0 {
-						err := b.Delete(in.TXid)
-						if err != nil {
-							log.Panic(err)
-						}
-					} else {
-						err := b.Put(in.TXid, updatedOuts.Serialize())
-						if err != nil {
-							log.Panic(err)
-						}
-					}
-				}
+	err = unindexTransactions(tx, block)
+	if err != nil {
+		return err
+	}
+
+	err = unindexHeight(tx, block)
+	if err != nil {
+		return err
+	}
+
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		transaction := block.Transactions[i]
+
+		err = b.Delete(transaction.ID)
+		if err != nil {
+			return err
+		}
+
+		if transaction.IsCionBase() {
+			continue
+		}
+
+		for j := len(transaction.TXInputs) - 1; j >= 0; j-- {
+			if len(spent) == 0 {
+				return fmt.Errorf("Undo data of block %x is incomplete !!!", block.Hash)
 			}
-			nOutputs := TXOutputs{}
-			for _, out := range tx.TXOutputs {
-				nOutputs.Outputs = append(nOutputs.Outputs, out)
+			restored := spent[len(spent)-1]
+			spent = spent[:len(spent)-1]
+
+			outs := TXOutputs{make(map[int]TXOutput), restored.Height, restored.IsCoinbase}
+			if outsBytes := b.Get(restored.TXid); outsBytes != nil {
+				outs = DeserializeOutputs(outsBytes)
 			}
+			outs.Outputs[restored.Index] = restored.Output
 
-			err := b.Put(tx.ID, nOutputs.Serialize())
+			err = b.Put(restored.TXid, outs.Serialize())
 			if err != nil {
-				log.Panic(err)
+				return err
 			}
 		}
-		return nil
-	})
-	if err != nil {
-		log.Panic(err)
 	}
+
+	return undos.Delete(block.Hash)
 }
@@ -0,0 +1,38 @@
+package features
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCoinbaseMaturity(t *testing.T) {
+	maturity := CoinbaseMaturity
+	t.Cleanup(func() { CoinbaseMaturity = maturity })
+	CoinbaseMaturity = 2
+
+	blockchain, _ := newTestChain(t)
+	miner := NewWallet()
+	minerHash := HashPubKey(miner.PublicKey)
+	UTXOSet := UTXOSet{blockchain}
+
+	// the coinbase of block 1 can be spent by blocks from height 3
+	block1 := addBlock(t, blockchain, blockchain.Tip, miner)
+	coinbase := block1.Transactions[0]
+	payment := spend(t, blockchain, miner, coinbase, 0, *NewTXOutput(coinbase.TXOutputs[0].Value, walletAddress(NewWallet())))
+
+	if accumulated, _ := UTXOSet.FindSpendableOutputs(minerHash, 1); accumulated != 0 {
+		t.Fatalf("%d of the immature coinbase is spendable", accumulated)
+	}
+
+	immature := buildBlock(t, blockchain, blockchain.Tip, NewCoinbaseTX(walletAddress(miner), "", 2, 0), payment)
+	if _, err := blockchain.AddBlock(immature); !errors.Is(err, ErrImmatureCoinbase) {
+		t.Fatalf("the block spending an immature coinbase returned %v", err)
+	}
+
+	addBlock(t, blockchain, blockchain.Tip, NewWallet())
+
+	if accumulated, outputs := UTXOSet.FindSpendableOutputs(minerHash, 1); accumulated != coinbase.TXOutputs[0].Value || len(outputs) != 1 {
+		t.Fatalf("%d of the mature coinbase is spendable", accumulated)
+	}
+	addBlock(t, blockchain, blockchain.Tip, miner, payment)
+}
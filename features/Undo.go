@@ -0,0 +1,37 @@
+package features
+
+import (
+	"log"
+)
+
+const undoBucket = "undo"
+
+// SpentOutput is an output a block spent, kept so it can be restored when the block is disconnected
+type SpentOutput struct {
+	TXid       []byte
+	Index      int
+	Output     TXOutput
+	Height     int
+	IsCoinbase bool
+}
+
+// BlockUndo lists the outputs a block spent, in the order its inputs spent them
+type BlockUndo struct {
+	Spent []SpentOutput
+}
+
+func (undo BlockUndo) Serialize() []byte {
+	var e encoder
+	undo.encode(&e)
+
+	return e.Bytes()
+}
+
+func DeserializeUndo(data []byte) BlockUndo {
+	undo, err := decodeUndo(data)
+	if err != nil {
+		log.Panic("Serialization Decoding Error!!!", err)
+	}
+
+	return undo
+}
@@ -0,0 +1,349 @@
+package features
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"sort"
+	"time"
+)
+
+// blocks may not be stamped further than this many seconds in the future
+const maxFutureBlockTime = 2 * 60 * 60
+
+// the timestamp of a block may not be older than the median of this many ancestors
+const medianTimeBlocks = 11
+
+// MaxMoney bounds every amount and every sum of amounts, far above any supply the
+// emission schedule allows, so adding two of them can never overflow
+const MaxMoney = 1 << 53
+
+// errors returned by AddBlock, so callers can tell why a block was rejected
+var (
+	ErrBadVersion        = errors.New("block or transaction version is not supported")
+	ErrOrphanBlock       = errors.New("previous block is not found")
+	ErrBadHeight         = errors.New("block height does not follow its parent")
+	ErrBadDifficulty     = errors.New("block difficulty does not match the expected difficulty")
+	ErrBadProofOfWork    = errors.New("block proof of work is invalid")
+	ErrBadTimestamp      = errors.New("block timestamp is out of bounds")
+	ErrBadMerkleRoot     = errors.New("block transactions do not match the mined hash")
+	ErrBadCoinbase       = errors.New("block coinbase is invalid")
+	ErrBadTransaction    = errors.New("transaction is malformed")
+	ErrBadSignature      = errors.New("transaction signature is invalid")
+	ErrMissingInput      = errors.New("transaction input is spent or does not exist")
+	ErrDoubleSpend       = errors.New("transaction output is spent twice in the block")
+	ErrImmatureCoinbase  = errors.New("transaction spends an immature coinbase output")
+	ErrInsufficientInput = errors.New("transaction outputs exceed its inputs")
+)
+
+// checkHeader validates the header against its parent header, which must be stored
+func checkHeader(tx *bolt.Tx, header *BlockHeader) error {
+	headers := tx.Bucket([]byte(headersBucket))
+
+	if header.Version != BlockVersion {
+		return fmt.Errorf("%w: version %d", ErrBadVersion, header.Version)
+	}
+
+	previous, err := getHeader(headers, header.GetPreviousHash())
+	if err != nil {
+		return fmt.Errorf("%w: %x", ErrOrphanBlock, header.GetPreviousHash())
+	}
+
+	if header.GetHeight() != previous.GetHeight()+1 {
+		return fmt.Errorf("%w: %d after %d", ErrBadHeight, header.GetHeight(), previous.GetHeight())
+	}
+
+	expected := nextDifficulty(headers, previous)
+	if header.GetDifficulty() != expected {
+		return fmt.Errorf("%w: %d instead of %d", ErrBadDifficulty, header.GetDifficulty(), expected)
+	}
+
+	if !NewPOW(header).Validate() {
+		return ErrBadProofOfWork
+	}
+
+	if header.TimeStamp < medianTimePast(headers, previous) {
+		return fmt.Errorf("%w: %d is before the median of its ancestors", ErrBadTimestamp, header.TimeStamp)
+	}
+	if header.TimeStamp > time.Now().Unix()+maxFutureBlockTime {
+		return fmt.Errorf("%w: %d is too far in the future", ErrBadTimestamp, header.TimeStamp)
+	}
+
+	return nil
+}
+
+// checkBlock validates everything that does not depend on the UTXO set, the parent
+// block must be stored and not only its header
+func checkBlock(tx *bolt.Tx, block *Block) error {
+	b := tx.Bucket([]byte(blocksBucket))
+
+	if b.Get(block.GetPreviousHash()) == nil {
+		return fmt.Errorf("%w: %x", ErrOrphanBlock, block.GetPreviousHash())
+	}
+
+	err := checkHeader(tx, &block.BlockHeader)
+	if err != nil {
+		return err
+	}
+
+	if len(block.Transactions) == 0 {
+		return fmt.Errorf("%w: block has no transactions", ErrBadMerkleRoot)
+	}
+
+	if !bytes.Equal(block.MerkleRoot, block.HashTransactions()) {
+		return fmt.Errorf("%w: %x", ErrBadMerkleRoot, block.MerkleRoot)
+	}
+
+	coinbases := 0
+	txIDs := make(map[string]bool)
+	for _, transaction := range block.Transactions {
+		if transaction.Version != TransactionVersion {
+			return fmt.Errorf("%w: transaction %x has version %d", ErrBadVersion, transaction.ID, transaction.Version)
+		}
+		if !bytes.Equal(transaction.ID, transaction.UnsignedHash()) {
+			return fmt.Errorf("%w: transaction %x has a wrong ID", ErrBadMerkleRoot, transaction.ID)
+		}
+
+		txID := hex.EncodeToString(transaction.ID)
+		if txIDs[txID] {
+			return fmt.Errorf("%w: transaction %x is included twice", ErrBadMerkleRoot, transaction.ID)
+		}
+		txIDs[txID] = true
+
+		if transaction.IsCionBase() {
+			coinbases++
+		}
+
+		_, err = outputTotal(transaction)
+		if err != nil {
+			return err
+		}
+	}
+
+	if coinbases != 1 {
+		return fmt.Errorf("%w: block has %d coinbase transactions", ErrBadCoinbase, coinbases)
+	}
+	if !block.Transactions[0].IsCionBase() {
+		return fmt.Errorf("%w: the coinbase is not the first transaction", ErrBadCoinbase)
+	}
+
+	return nil
+}
+
+// checkTransactions validates the transactions of a block against the UTXO set,
+// which must be at the state of the block's parent
+func checkTransactions(tx *bolt.Tx, block *Block) error {
+	created := make(map[string]Transaction)
+	spent := make(map[string]bool)
+	fees := 0
+	var coinbase *Transaction
+
+	for _, transaction := range block.Transactions {
+		err := checkNewID(tx, transaction)
+		if err != nil {
+			return err
+		}
+
+		if transaction.IsCionBase() {
+			coinbase = transaction
+			created[hex.EncodeToString(transaction.ID)] = *transaction
+			continue
+		}
+
+		fee, err := checkSpend(tx, transaction, block.GetHeight(), created, spent)
+		if err != nil {
+			return err
+		}
+		var ok bool
+		fees, ok = addMoney(fees, fee)
+		if !ok {
+			return fmt.Errorf("%w: the fees of the block exceed %d", ErrBadTransaction, MaxMoney)
+		}
+
+		created[hex.EncodeToString(transaction.ID)] = *transaction
+	}
+
+	reward, err := outputTotal(coinbase)
+	if err != nil {
+		return err
+	}
+	available := Emission.Subsidy(block.GetHeight()) + fees
+	if reward > available {
+		return fmt.Errorf("%w: claims %d but only %d is available", ErrBadCoinbase, reward, available)
+	}
+
+	return nil
+}
+
+// checkSpend validates a transaction of a block at height and returns its fee. It may spend
+// the outputs of the UTXO set and of the transactions created earlier in the block, spent
+// holds the outputs these already spent and gets the ones of the transaction.
+func checkSpend(tx *bolt.Tx, transaction *Transaction, height int, created map[string]Transaction, spent map[string]bool) (int, error) {
+	utxos := tx.Bucket([]byte(UTXOBucket))
+
+	if len(transaction.TXInputs) == 0 || len(transaction.TXOutputs) == 0 {
+		return 0, fmt.Errorf("%w: %x has no inputs or outputs", ErrBadTransaction, transaction.ID)
+	}
+
+	inputs := 0
+	previousTXs := make(map[string]Transaction)
+
+	for _, in := range transaction.TXInputs {
+		txID := hex.EncodeToString(in.TXid)
+		outpoint := fmt.Sprintf("%s:%d", txID, in.Value)
+
+		if spent[outpoint] {
+			return 0, fmt.Errorf("%w: %s", ErrDoubleSpend, outpoint)
+		}
+		spent[outpoint] = true
+
+		var out TXOutput
+		if previousTX, ok := created[txID]; ok {
+			if in.Value < 0 || in.Value >= len(previousTX.TXOutputs) {
+				return 0, fmt.Errorf("%w: %s", ErrMissingInput, outpoint)
+			}
+			if previousTX.IsCionBase() && CoinbaseMaturity > 0 {
+				return 0, fmt.Errorf("%w: %s", ErrImmatureCoinbase, outpoint)
+			}
+			out = previousTX.TXOutputs[in.Value]
+			previousTXs[txID] = previousTX
+		} else {
+			outsBytes := utxos.Get(in.TXid)
+			if outsBytes == nil {
+				return 0, fmt.Errorf("%w: %s", ErrMissingInput, outpoint)
+			}
+			outs := DeserializeOutputs(outsBytes)
+			unspent, ok := outs.Outputs[in.Value]
+			if !ok {
+				return 0, fmt.Errorf("%w: %s", ErrMissingInput, outpoint)
+			}
+			if !outs.IsMature(height) {
+				return 0, fmt.Errorf("%w: %s", ErrImmatureCoinbase, outpoint)
+			}
+			out = unspent
+
+			previousTX, err := lookupTransaction(tx, in.TXid)
+			if err != nil {
+				return 0, fmt.Errorf("%w: %s", ErrMissingInput, outpoint)
+			}
+			previousTXs[txID] = previousTX
+		}
+
+		var ok bool
+		inputs, ok = addMoney(inputs, out.Value)
+		if !ok {
+			return 0, fmt.Errorf("%w: %x spends more than %d", ErrBadTransaction, transaction.ID, MaxMoney)
+		}
+	}
+
+	outputs, err := outputTotal(transaction)
+	if err != nil {
+		return 0, err
+	}
+
+	if outputs > inputs {
+		return 0, fmt.Errorf("%w: %x spends %d of %d", ErrInsufficientInput, transaction.ID, outputs, inputs)
+	}
+
+	if !transaction.Verify(previousTXs, height) {
+		return 0, fmt.Errorf("%w: %x", ErrBadSignature, transaction.ID)
+	}
+
+	return inputs - outputs, nil
+}
+
+// ValidateTransaction checks a transaction that is in no block yet as if the next block
+// included it on its own, and returns its fee. Nodes run it on the transactions they
+// receive so that invalid ones never enter their mempool.
+func (blockchain *BlockChain) ValidateTransaction(transaction *Transaction) (int, error) {
+	if transaction.Version != TransactionVersion {
+		return 0, fmt.Errorf("%w: transaction %x has version %d", ErrBadVersion, transaction.ID, transaction.Version)
+	}
+	if transaction.IsCionBase() {
+		return 0, fmt.Errorf("%w: %x is a coinbase outside of a block", ErrBadTransaction, transaction.ID)
+	}
+	if !bytes.Equal(transaction.ID, transaction.UnsignedHash()) {
+		return 0, fmt.Errorf("%w: %x has a wrong ID", ErrBadTransaction, transaction.ID)
+	}
+
+	fee := 0
+	err := blockchain.DB.View(func(tx *bolt.Tx) error {
+		err := checkNewID(tx, transaction)
+		if err != nil {
+			return err
+		}
+
+		tip, err := getHeader(tx.Bucket([]byte(headersBucket)), tx.Bucket([]byte(blocksBucket)).Get([]byte("l")))
+		if err != nil {
+			return err
+		}
+
+		fee, err = checkSpend(tx, transaction, tip.GetHeight()+1, make(map[string]Transaction), make(map[string]bool))
+		return err
+	})
+
+	return fee, err
+}
+
+// checkNewID rejects a transaction whose ID still has unspent outputs. Connecting it would
+// overwrite them, and disconnecting its block later would delete them.
+func checkNewID(tx *bolt.Tx, transaction *Transaction) error {
+	if tx.Bucket([]byte(UTXOBucket)).Get(transaction.ID) != nil {
+		return fmt.Errorf("%w: %x already has unspent outputs", ErrBadTransaction, transaction.ID)
+	}
+
+	return nil
+}
+
+// outputTotal sums the outputs of the transaction, none of them may be negative
+// and neither they nor their sum may exceed MaxMoney
+func outputTotal(transaction *Transaction) (int, error) {
+	total := 0
+
+	for id, out := range transaction.TXOutputs {
+		if out.Value < 0 {
+			return 0, fmt.Errorf("%w: %x has a negative output %d", ErrBadTransaction, transaction.ID, id)
+		}
+
+		var ok bool
+		total, ok = addMoney(total, out.Value)
+		if !ok {
+			return 0, fmt.Errorf("%w: %x pays more than %d", ErrBadTransaction, transaction.ID, MaxMoney)
+		}
+	}
+
+	return total, nil
+}
+
+// addMoney adds an amount to a sum, it fails when the amount is out of range or the sum exceeds MaxMoney
+func addMoney(sum, amount int) (int, bool) {
+	if amount < 0 || amount > MaxMoney || sum > MaxMoney-amount {
+		return sum, false
+	}
+
+	return sum + amount, true
+}
+
+// medianTimePast returns the median timestamp of the last medianTimeBlocks headers ending at header
+func medianTimePast(headers *bolt.Bucket, header *BlockHeader) int64 {
+	var timestamps []int64
+
+	current := header
+	for {
+		timestamps = append(timestamps, current.TimeStamp)
+		if len(timestamps) == medianTimeBlocks || len(current.PreviousHash) == 0 {
+			break
+		}
+
+		var err error
+		current, err = getHeader(headers, current.PreviousHash)
+		if err != nil {
+			break
+		}
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2]
+}
@@ -0,0 +1,99 @@
+package features
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestCheckTransactionsRejects(t *testing.T) {
+	tests := []struct {
+		name     string
+		build    func(t *testing.T, blockchain *BlockChain, wallet *Wallet) []*Transaction
+		expected error
+	}{
+		{"valid spend", func(t *testing.T, blockchain *BlockChain, wallet *Wallet) []*Transaction {
+			genesis := genesisTX(t, blockchain)
+			return []*Transaction{spend(t, blockchain, wallet, genesis, 0, *NewTXOutput(9, walletAddress(wallet)))}
+		}, nil},
+		{"negative coinbase output", func(t *testing.T, blockchain *BlockChain, wallet *Wallet) []*Transaction {
+			coinbase := NewCoinbaseTX(walletAddress(wallet), "", 1, 0)
+			coinbase.TXOutputs = []TXOutput{*NewTXOutput(1000, walletAddress(wallet)), *NewTXOutput(-990, walletAddress(wallet))}
+			coinbase.ID = coinbase.Hash()
+			return []*Transaction{coinbase}
+		}, ErrBadTransaction},
+		{"overflowing outputs", func(t *testing.T, blockchain *BlockChain, wallet *Wallet) []*Transaction {
+			genesis := genesisTX(t, blockchain)
+			out := *NewTXOutput(math.MaxInt64, walletAddress(wallet))
+			return []*Transaction{spend(t, blockchain, wallet, genesis, 0, out, out)}
+		}, ErrBadTransaction},
+		{"output above the money limit", func(t *testing.T, blockchain *BlockChain, wallet *Wallet) []*Transaction {
+			genesis := genesisTX(t, blockchain)
+			return []*Transaction{spend(t, blockchain, wallet, genesis, 0, *NewTXOutput(MaxMoney+1, walletAddress(wallet)))}
+		}, ErrBadTransaction},
+		{"negative output", func(t *testing.T, blockchain *BlockChain, wallet *Wallet) []*Transaction {
+			genesis := genesisTX(t, blockchain)
+			return []*Transaction{spend(t, blockchain, wallet, genesis, 0,
+				*NewTXOutput(15, walletAddress(wallet)), *NewTXOutput(-5, walletAddress(wallet)))}
+		}, ErrBadTransaction},
+		{"missing input", func(t *testing.T, blockchain *BlockChain, wallet *Wallet) []*Transaction {
+			input := TXInput{make([]byte, 32), 0, nil, wallet.PublicKey, nil}
+			transaction := Transaction{nil, []TXInput{input}, []TXOutput{*NewTXOutput(1, walletAddress(wallet))}, TransactionVersion}
+			transaction.ID = transaction.Hash()
+			return []*Transaction{&transaction}
+		}, ErrMissingInput},
+		{"double spend", func(t *testing.T, blockchain *BlockChain, wallet *Wallet) []*Transaction {
+			genesis := genesisTX(t, blockchain)
+			return []*Transaction{
+				spend(t, blockchain, wallet, genesis, 0, *NewTXOutput(9, walletAddress(wallet))),
+				spend(t, blockchain, wallet, genesis, 0, *NewTXOutput(8, walletAddress(wallet))),
+			}
+		}, ErrDoubleSpend},
+		{"outputs exceed inputs", func(t *testing.T, blockchain *BlockChain, wallet *Wallet) []*Transaction {
+			genesis := genesisTX(t, blockchain)
+			return []*Transaction{spend(t, blockchain, wallet, genesis, 0, *NewTXOutput(11, walletAddress(wallet)))}
+		}, ErrInsufficientInput},
+		{"signed by another key", func(t *testing.T, blockchain *BlockChain, wallet *Wallet) []*Transaction {
+			genesis := genesisTX(t, blockchain)
+			transaction := spend(t, blockchain, wallet, genesis, 0, *NewTXOutput(9, walletAddress(wallet)))
+			err := blockchain.SignTransaction(transaction, *NewWallet().PrivateKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return []*Transaction{transaction}
+		}, ErrBadSignature},
+		{"coinbase above the subsidy", func(t *testing.T, blockchain *BlockChain, wallet *Wallet) []*Transaction {
+			return []*Transaction{NewCoinbaseTX(walletAddress(wallet), "", 1, 1)}
+		}, ErrBadCoinbase},
+		{"coinbase after a spend", func(t *testing.T, blockchain *BlockChain, wallet *Wallet) []*Transaction {
+			genesis := genesisTX(t, blockchain)
+			return []*Transaction{
+				spend(t, blockchain, wallet, genesis, 0, *NewTXOutput(9, walletAddress(wallet))),
+				NewCoinbaseTX(walletAddress(wallet), "", 1, 1),
+			}
+		}, ErrBadCoinbase},
+		{"coinbase repeating an unspent one", func(t *testing.T, blockchain *BlockChain, wallet *Wallet) []*Transaction {
+			return []*Transaction{genesisTX(t, blockchain)}
+		}, ErrBadTransaction},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			blockchain, wallet := newTestChain(t)
+
+			transactions := test.build(t, blockchain, wallet)
+			if !transactions[len(transactions)-1].IsCionBase() && !transactions[0].IsCionBase() {
+				coinbase := NewCoinbaseTX(walletAddress(wallet), "", 1, 0)
+				transactions = append([]*Transaction{coinbase}, transactions...)
+			}
+
+			_, err := blockchain.AddBlock(buildBlock(t, blockchain, blockchain.Tip, transactions...))
+			if test.expected == nil && err != nil {
+				t.Fatalf("the block was rejected: %s", err)
+			}
+			if test.expected != nil && !errors.Is(err, test.expected) {
+				t.Fatalf("got %v instead of %v", err, test.expected)
+			}
+		})
+	}
+}
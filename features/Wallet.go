@@ -7,30 +7,73 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/gob"
+	"errors"
 	"log"
 
 	"golang.org/x/crypto/ripemd160"
 )
 
 const version = byte(0x00)
+const scriptVersion = byte(0x05)
 const addressChecksumLen = 4
 
 type Wallet struct {
 	PrivateKey *ecdsa.PrivateKey
 	PublicKey  []byte
+	// derivation path of keys generated from the wallet seed, empty for random keys
+	Path string
+}
+
+// serializedWallet is how a Wallet is stored, gob cannot encode the curve of an ecdsa key
+type serializedWallet struct {
+	PrivateKey SerializePrivateKey
+	PublicKey  []byte
+	Path       string
 }
 
 func NewWallet() *Wallet {
 	private, public := NewKeyPair()
-	wallet := Wallet{&private, public}
+	wallet := Wallet{&private, public, ""}
 
 	return &wallet
 }
 
+func (w Wallet) GobEncode() ([]byte, error) {
+	var buff bytes.Buffer
+
+	privateKey := SerializePrivateKey{w.PrivateKey.D, w.PrivateKey.X, w.PrivateKey.Y, *w.PrivateKey.Curve.Params()}
+	err := gob.NewEncoder(&buff).Encode(serializedWallet{privateKey, w.PublicKey, w.Path})
+
+	return buff.Bytes(), err
+}
+
+func (w *Wallet) GobDecode(data []byte) error {
+	var wallet serializedWallet
+
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&wallet)
+	if err != nil {
+		return err
+	}
+	if wallet.PrivateKey.Curve.Name != elliptic.P256().Params().Name {
+		return errors.New("Wallet key is not on the P-256 curve !!!")
+	}
+
+	private := ecdsa.PrivateKey{D: wallet.PrivateKey.D}
+	private.PublicKey = ecdsa.PublicKey{Curve: elliptic.P256(), X: wallet.PrivateKey.X, Y: wallet.PrivateKey.Y}
+	*w = Wallet{&private, wallet.PublicKey, wallet.Path}
+
+	return nil
+}
+
 func (w Wallet) GetAddress() []byte {
 	pubKeyHash := HashPubKey(w.PublicKey)
 
-	versionedPayload := append([]byte{version}, pubKeyHash...)
+	return encodeAddress(version, pubKeyHash)
+}
+
+func encodeAddress(version byte, hash []byte) []byte {
+	versionedPayload := append([]byte{version}, hash...)
 	checksum := checksum(versionedPayload)
 
 	fullPayload := append(versionedPayload, checksum...)
@@ -63,13 +106,20 @@ func NewKeyPair() (ecdsa.PrivateKey, []byte) {
 	if err != nil {
 		log.Panic(err)
 	}
-	pubKey := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
 
-	return *private, pubKey
+	return *private, publicKeyBytes(private.PublicKey)
+}
+
+// publicKeyBytes returns X and Y of the key, each padded to 32 bytes
+func publicKeyBytes(publicKey ecdsa.PublicKey) []byte {
+	return append(publicKey.X.FillBytes(make([]byte, 32)), publicKey.Y.FillBytes(make([]byte, 32))...)
 }
 
 func ValidateAddress(address string) bool {
 	pubKeyHash := utils.Base64Decode([]byte(address))
+	if len(pubKeyHash) <= addressChecksumLen {
+		return false
+	}
 	realChecksum := pubKeyHash[len(pubKeyHash)-addressChecksumLen:]
 	version := pubKeyHash[0]
 	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-addressChecksumLen]
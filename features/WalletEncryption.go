@@ -0,0 +1,240 @@
+package features
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// encrypted wallet files start with this header, plain ones are a bare gob stream
+const encryptedWalletMagic = "COMP5567-ENCRYPTED-WALLET"
+
+// an unlocked session keeps the wallet key in this file until it expires
+const walletSessionFile = "wallet_%s.session"
+
+// scrypt cost of deriving the wallet key from the passphrase
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	walletKeyLen = 32
+)
+
+var (
+	ErrWalletLocked    = errors.New("wallet is locked")
+	ErrWrongPassphrase = errors.New("wallet passphrase is wrong")
+)
+
+// encryptedWallet is the content of an encrypted wallet file after its header
+type encryptedWallet struct {
+	Salt       []byte
+	N          int
+	R          int
+	P          int
+	Nonce      []byte
+	Ciphertext []byte
+}
+
+// walletSession is an unlocked wallet key and when it stops being valid
+type walletSession struct {
+	Key     []byte
+	Salt    []byte
+	Expires int64
+}
+
+// OpenWallets loads the wallet file of the node, decrypting it with the passphrase
+func OpenWallets(nodeID, passphrase string) (*Wallets, error) {
+	wallets := Wallets{}
+	wallets.Wallets = make(map[string]*Wallet)
+	wallets.Multisigs = make(map[string]*MultisigWallet)
+	wallets.WatchOnly = make(map[string]*WatchOnlyWallet)
+
+	fileContent, err := os.ReadFile(fmt.Sprintf(walletFile, nodeID))
+	if err != nil {
+		return &wallets, err
+	}
+
+	if !isEncryptedWallet(fileContent) {
+		return &wallets, wallets.decode(fileContent)
+	}
+
+	envelope, err := decodeEncryptedWallet(fileContent)
+	if err != nil {
+		return &wallets, err
+	}
+
+	key, err := scrypt.Key([]byte(passphrase), envelope.Salt, envelope.N, envelope.R, envelope.P, walletKeyLen)
+	if err != nil {
+		return &wallets, err
+	}
+
+	return &wallets, wallets.decrypt(envelope, key)
+}
+
+// IsEncrypted reports whether the wallet is saved encrypted
+func (ws *Wallets) IsEncrypted() bool {
+	return ws.key != nil
+}
+
+// SetPassphrase makes SaveToFile encrypt the wallet with a key derived from the passphrase
+func (ws *Wallets) SetPassphrase(passphrase string) error {
+	if passphrase == "" {
+		return errors.New("The passphrase is empty !!!")
+	}
+
+	salt := make([]byte, 16)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return err
+	}
+
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, walletKeyLen)
+	if err != nil {
+		return err
+	}
+
+	ws.key = key
+	ws.salt = salt
+
+	return nil
+}
+
+// Unlock keeps the wallet key of the node in a session file only its owner can read,
+// so the wallet opens without its passphrase until the timeout passes. The caller
+// removes the file with LockWallets when the session ends.
+func (ws *Wallets) Unlock(nodeID string, timeout time.Duration) error {
+	if !ws.IsEncrypted() {
+		return errors.New("The wallet is not encrypted !!!")
+	}
+
+	var buff bytes.Buffer
+	session := walletSession{ws.key, ws.salt, time.Now().Add(timeout).Unix()}
+	err := gob.NewEncoder(&buff).Encode(session)
+	if err != nil {
+		return err
+	}
+
+	return writePrivateFile(fmt.Sprintf(walletSessionFile, nodeID), buff.Bytes())
+}
+
+// LockWallets ends the unlocked session of the node
+func LockWallets(nodeID string) error {
+	err := os.Remove(fmt.Sprintf(walletSessionFile, nodeID))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// loadSession returns the key of an unlocked session, the session is removed once expired
+func loadSession(nodeID string) (*walletSession, error) {
+	sessionFile := fmt.Sprintf(walletSessionFile, nodeID)
+
+	fileContent, err := os.ReadFile(sessionFile)
+	if os.IsNotExist(err) {
+		return nil, ErrWalletLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var session walletSession
+	err = gob.NewDecoder(bytes.NewReader(fileContent)).Decode(&session)
+	if err != nil || time.Now().Unix() >= session.Expires {
+		os.Remove(sessionFile)
+		return nil, ErrWalletLocked
+	}
+
+	return &session, nil
+}
+
+// encrypt seals the gob encoded wallets with the wallet key
+func (ws *Wallets) encrypt(plaintext []byte) ([]byte, error) {
+	gcm, err := newWalletCipher(ws.key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	envelope := encryptedWallet{ws.salt, scryptN, scryptR, scryptP, nonce, gcm.Seal(nil, nonce, plaintext, []byte(encryptedWalletMagic))}
+
+	buff := bytes.NewBufferString(encryptedWalletMagic)
+	err = gob.NewEncoder(buff).Encode(envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
+
+// decrypt opens the envelope with the key and loads the wallets in it
+func (ws *Wallets) decrypt(envelope *encryptedWallet, key []byte) error {
+	gcm, err := newWalletCipher(key)
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := gcm.Open(nil, envelope.Nonce, envelope.Ciphertext, []byte(encryptedWalletMagic))
+	if err != nil {
+		return ErrWrongPassphrase
+	}
+
+	err = ws.decode(plaintext)
+	if err != nil {
+		return err
+	}
+
+	ws.key = key
+	ws.salt = envelope.Salt
+
+	return nil
+}
+
+func newWalletCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func isEncryptedWallet(fileContent []byte) bool {
+	return bytes.HasPrefix(fileContent, []byte(encryptedWalletMagic))
+}
+
+func decodeEncryptedWallet(fileContent []byte) (*encryptedWallet, error) {
+	var envelope encryptedWallet
+
+	decoder := gob.NewDecoder(bytes.NewReader(fileContent[len(encryptedWalletMagic):]))
+	err := decoder.Decode(&envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	return &envelope, nil
+}
+
+// writePrivateFile writes a file only its owner can read, also when it already exists
+func writePrivateFile(name string, data []byte) error {
+	err := os.WriteFile(name, data, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Chmod(name, 0600)
+}
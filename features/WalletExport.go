@@ -0,0 +1,315 @@
+package features
+
+// Wallets are exported to a directory that other tools can read without Go:
+//
+//	manifest.json    describes the wallet, see walletManifest
+//	key-N.pem        one "PRIVATE KEY" PEM block per key, PKCS#8 DER of a P-256 key,
+//	                 the same as `openssl genpkey -algorithm EC -pkeyopt ec_paramgen_curve:P-256`
+//
+// manifest.json looks like
+//
+//	{
+//	  "version": 1,
+//	  "mnemonic": "seed phrase, omitted when the wallet has none",
+//	  "nextIndex": 2,
+//	  "keys": [{"address": "ADDRESS", "path": "m/44'/5567'/0'/0/0", "file": "key-0.pem"}],
+//	  "multisigs": [{"address": "ADDRESS", "required": 2, "publicKeys": ["HEX", "HEX"]}],
+//	  "watchOnly": [{"address": "ADDRESS", "publicKey": "HEX, omitted when unknown"}]
+//	}
+//
+// Public keys are hex encoded X and Y, 32 bytes each. A single key is exported as a
+// PEM file on its own, "PUBLIC KEY" blocks (PKIX DER) import as watch-only addresses.
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+)
+
+const walletManifestFile = "manifest.json"
+const walletManifestVersion = 1
+
+type walletManifest struct {
+	Version   int                 `json:"version"`
+	Mnemonic  string              `json:"mnemonic,omitempty"`
+	NextIndex uint32              `json:"nextIndex"`
+	Keys      []manifestKey       `json:"keys"`
+	Multisigs []manifestMultisig  `json:"multisigs"`
+	WatchOnly []manifestWatchOnly `json:"watchOnly"`
+}
+
+type manifestKey struct {
+	Address string `json:"address"`
+	Path    string `json:"path,omitempty"`
+	File    string `json:"file"`
+}
+
+type manifestMultisig struct {
+	Address    string   `json:"address"`
+	Required   int      `json:"required"`
+	PublicKeys []string `json:"publicKeys"`
+}
+
+type manifestWatchOnly struct {
+	Address   string `json:"address"`
+	PublicKey string `json:"publicKey,omitempty"`
+}
+
+// ExportKey returns the PEM block of an address, a private key for own
+// addresses and a public key for watch-only ones
+func (ws *Wallets) ExportKey(address string) ([]byte, error) {
+	if wallet, ok := ws.Wallets[address]; ok {
+		der, err := x509.MarshalPKCS8PrivateKey(wallet.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	}
+
+	if wallet, ok := ws.WatchOnly[address]; ok && len(wallet.PublicKey) > 0 {
+		der, err := x509.MarshalPKIXPublicKey(parsePublicKey(wallet.PublicKey))
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+	}
+
+	return nil, fmt.Errorf("No key of %s is in the wallet !!!", address)
+}
+
+// ImportKeys adds every key in the PEM data and returns their addresses, private keys
+// are added as wallets and public keys as watch-only addresses
+func (ws *Wallets) ImportKeys(data []byte) ([]string, error) {
+	var addresses []string
+
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		var address string
+		var err error
+		switch block.Type {
+		case "PRIVATE KEY", "EC PRIVATE KEY":
+			address, err = ws.importPrivateKey(block, "")
+		case "PUBLIC KEY":
+			address, err = ws.importPublicKey(block)
+		default:
+			err = fmt.Errorf("PEM block %s is not supported !!!", block.Type)
+		}
+		if err != nil {
+			return addresses, err
+		}
+
+		addresses = append(addresses, address)
+	}
+
+	if len(addresses) == 0 {
+		return nil, errors.New("No PEM encoded key is found !!!")
+	}
+
+	return addresses, nil
+}
+
+// Export writes the whole wallet to the directory, which is created when missing
+func (ws *Wallets) Export(dir string) error {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	manifest := walletManifest{walletManifestVersion, ws.Mnemonic, ws.NextIndex, []manifestKey{}, []manifestMultisig{}, []manifestWatchOnly{}}
+
+	for i, address := range ws.GetAddresses() {
+		data, err := ws.ExportKey(address)
+		if err != nil {
+			return err
+		}
+
+		file := fmt.Sprintf("key-%d.pem", i)
+		err = writePrivateFile(filepath.Join(dir, file), data)
+		if err != nil {
+			return err
+		}
+
+		manifest.Keys = append(manifest.Keys, manifestKey{address, ws.Wallets[address].Path, file})
+	}
+
+	for _, address := range ws.GetMultisigAddresses() {
+		multisig := ws.Multisigs[address]
+		var publicKeys []string
+		for _, publicKey := range multisig.PublicKeys {
+			publicKeys = append(publicKeys, hex.EncodeToString(publicKey))
+		}
+
+		manifest.Multisigs = append(manifest.Multisigs, manifestMultisig{address, multisig.M, publicKeys})
+	}
+
+	for address, wallet := range ws.WatchOnly {
+		manifest.WatchOnly = append(manifest.WatchOnly, manifestWatchOnly{address, hex.EncodeToString(wallet.PublicKey)})
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writePrivateFile(filepath.Join(dir, walletManifestFile), data)
+}
+
+// Import adds everything in an exported wallet directory and returns how many entries it
+// added. The seed phrase is only taken over by a wallet that has none yet.
+func (ws *Wallets) Import(dir string) (int, error) {
+	data, err := os.ReadFile(filepath.Join(dir, walletManifestFile))
+	if err != nil {
+		return 0, err
+	}
+
+	var manifest walletManifest
+	err = json.Unmarshal(data, &manifest)
+	if err != nil {
+		return 0, err
+	}
+	if manifest.Version != walletManifestVersion {
+		return 0, fmt.Errorf("Wallet manifest version %d is not supported !!!", manifest.Version)
+	}
+
+	keepPaths := manifest.Mnemonic != "" && (ws.Mnemonic == "" || ws.Mnemonic == manifest.Mnemonic)
+	if manifest.Mnemonic != "" && ws.Mnemonic == "" {
+		_, err = MnemonicToSeed(manifest.Mnemonic)
+		if err != nil {
+			return 0, err
+		}
+		ws.Mnemonic = manifest.Mnemonic
+	}
+	if keepPaths && manifest.NextIndex > ws.NextIndex {
+		ws.NextIndex = manifest.NextIndex
+	}
+
+	imported := 0
+
+	for _, key := range manifest.Keys {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.Base(key.File)))
+		if err != nil {
+			return imported, err
+		}
+
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return imported, fmt.Errorf("%s is not PEM encoded !!!", key.File)
+		}
+
+		path := ""
+		if keepPaths {
+			path = key.Path
+		}
+		address, err := ws.importPrivateKey(block, path)
+		if err != nil {
+			return imported, err
+		}
+		if address != key.Address {
+			return imported, fmt.Errorf("%s holds the key of %s instead of %s !!!", key.File, address, key.Address)
+		}
+		imported++
+	}
+
+	for _, multisig := range manifest.Multisigs {
+		var publicKeys [][]byte
+		for _, publicKey := range multisig.PublicKeys {
+			decoded, err := hex.DecodeString(publicKey)
+			if err != nil {
+				return imported, err
+			}
+			publicKeys = append(publicKeys, decoded)
+		}
+
+		address, err := ws.CreateMultisig(multisig.Required, publicKeys)
+		if err != nil {
+			return imported, err
+		}
+		if address != multisig.Address {
+			return imported, fmt.Errorf("Multisig keys do not match %s !!!", multisig.Address)
+		}
+		imported++
+	}
+
+	for _, watchOnly := range manifest.WatchOnly {
+		publicKey, err := hex.DecodeString(watchOnly.PublicKey)
+		if err != nil {
+			return imported, err
+		}
+
+		if ws.Wallets[watchOnly.Address] != nil {
+			continue
+		}
+		err = ws.AddWatchOnly(watchOnly.Address, publicKey)
+		if err != nil {
+			return imported, err
+		}
+		imported++
+	}
+
+	return imported, nil
+}
+
+func (ws *Wallets) importPrivateKey(block *pem.Block, path string) (string, error) {
+	var key interface{}
+	var err error
+	if block.Type == "EC PRIVATE KEY" {
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	} else {
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	private, ok := key.(*ecdsa.PrivateKey)
+	if !ok || private.Curve != elliptic.P256() {
+		return "", errors.New("Only P-256 private keys are supported !!!")
+	}
+
+	wallet := Wallet{private, publicKeyBytes(private.PublicKey), path}
+	address := fmt.Sprintf("%s", wallet.GetAddress())
+
+	ws.Wallets[address] = &wallet
+	delete(ws.WatchOnly, address)
+
+	return address, nil
+}
+
+func (ws *Wallets) importPublicKey(block *pem.Block) (string, error) {
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return "", err
+	}
+
+	public, ok := key.(*ecdsa.PublicKey)
+	if !ok || public.Curve != elliptic.P256() {
+		return "", errors.New("Only P-256 public keys are supported !!!")
+	}
+
+	publicKey := publicKeyBytes(*public)
+	address := fmt.Sprintf("%s", encodeAddress(version, HashPubKey(publicKey)))
+
+	return address, ws.AddWatchOnly(address, publicKey)
+}
+
+// parsePublicKey turns X and Y of a public key back into an ecdsa key
+func parsePublicKey(publicKey []byte) *ecdsa.PublicKey {
+	keyLen := len(publicKey)
+	x := new(big.Int).SetBytes(publicKey[:keyLen/2])
+	y := new(big.Int).SetBytes(publicKey[keyLen/2:])
+
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+}
@@ -4,17 +4,29 @@ import (
 	"bytes"
 	"crypto/elliptic"
 	"encoding/gob"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"math/big"
 	"os"
+	"strings"
 )
 
 const walletFile = "wallet_%s.msg"
 
 // Wallets stores a collection of wallets
 type Wallets struct {
-	Wallets map[string]*Wallet
+	Wallets   map[string]*Wallet
+	Multisigs map[string]*MultisigWallet
+	WatchOnly map[string]*WatchOnlyWallet
+	// seed phrase the addresses are derived from, and the index of the next address
+	Mnemonic  string
+	NextIndex uint32
+
+	// key and salt of an encrypted wallet file, never saved in the clear
+	key  []byte
+	salt []byte
 }
 
 type SerializePrivateKey struct {
@@ -28,22 +40,131 @@ type SerializePrivateKey struct {
 func NewWallets(nodeID string) (*Wallets, error) {
 	wallets := Wallets{}
 	wallets.Wallets = make(map[string]*Wallet)
+	wallets.Multisigs = make(map[string]*MultisigWallet)
+	wallets.WatchOnly = make(map[string]*WatchOnlyWallet)
 
 	err := wallets.LoadFromFile(nodeID)
 
 	return &wallets, err
 }
 
-// CreateWallet adds a Wallet to Wallets
+// CreateWallet derives the next address from the wallet seed and adds it to Wallets,
+// a new seed is generated the first time
 func (ws *Wallets) CreateWallet() string {
-	wallet := NewWallet()
+	if ws.Mnemonic == "" {
+		ws.Mnemonic = NewMnemonic()
+	}
+
+	wallet := ws.deriveWallet(ws.NextIndex)
 	address := fmt.Sprintf("%s", wallet.GetAddress())
 
 	ws.Wallets[address] = wallet
+	ws.NextIndex++
 
 	return address
 }
 
+// AddWatchOnly tracks an address without its private key, publicKey may be nil
+func (ws *Wallets) AddWatchOnly(address string, publicKey []byte) error {
+	if ws.Wallets[address] != nil {
+		return errors.New("The address is already in the wallet with its key !!!")
+	}
+
+	wallet, err := NewWatchOnlyWallet(address, publicKey)
+	if err != nil {
+		return err
+	}
+
+	ws.WatchOnly[address] = wallet
+
+	return nil
+}
+
+// ImportMnemonic sets the seed of a wallet that has none yet and regenerates its addresses
+func (ws *Wallets) ImportMnemonic(mnemonic string) error {
+	if ws.Mnemonic != "" {
+		return errors.New("The wallet already has a seed !!!")
+	}
+
+	_, err := MnemonicToSeed(mnemonic)
+	if err != nil {
+		return err
+	}
+
+	ws.Mnemonic = strings.Join(strings.Fields(strings.ToLower(mnemonic)), " ")
+	ws.Regenerate()
+
+	return nil
+}
+
+// Regenerate derives every address below NextIndex from the seed again
+func (ws *Wallets) Regenerate() {
+	for index := uint32(0); index < ws.NextIndex; index++ {
+		wallet := ws.deriveWallet(index)
+		ws.Wallets[fmt.Sprintf("%s", wallet.GetAddress())] = wallet
+	}
+}
+
+// Scan derives addresses from the seed until gapLimit of them in a row own no
+// unspent outputs, and keeps every address up to the last used one.
+// It returns how many used addresses were found.
+func (ws *Wallets) Scan(UTXOSet *UTXOSet, gapLimit int) int {
+	if ws.Mnemonic == "" {
+		return 0
+	}
+
+	used := UTXOSet.PubKeyHashes()
+	found := 0
+	gap := 0
+
+	for index := uint32(0); gap < gapLimit; index++ {
+		wallet := ws.deriveWallet(index)
+		if !used[hex.EncodeToString(HashPubKey(wallet.PublicKey))] {
+			gap++
+			continue
+		}
+
+		found++
+		gap = 0
+		if index >= ws.NextIndex {
+			ws.NextIndex = index + 1
+		}
+	}
+
+	ws.Regenerate()
+
+	return found
+}
+
+// deriveWallet returns the key pair at the address index
+func (ws *Wallets) deriveWallet(index uint32) *Wallet {
+	seed, err := MnemonicToSeed(ws.Mnemonic)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	path := walletPath(index)
+	key, err := NewMasterKey(seed).Derive(path)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return key.Wallet(path)
+}
+
+// CreateMultisig adds an m-of-n multisig wallet of the public keys to Wallets
+func (ws *Wallets) CreateMultisig(m int, publicKeys [][]byte) (string, error) {
+	wallet, err := NewMultisigWallet(m, publicKeys)
+	if err != nil {
+		return "", err
+	}
+	address := fmt.Sprintf("%s", wallet.GetAddress())
+
+	ws.Multisigs[address] = wallet
+
+	return address, nil
+}
+
 // GetAddresses returns an array of addresses stored in the wallet file
 func (ws *Wallets) GetAddresses() []string {
 	var addresses []string
@@ -55,12 +176,57 @@ func (ws *Wallets) GetAddresses() []string {
 	return addresses
 }
 
+// GetMultisigAddresses returns an array of multisig addresses stored in the wallet file
+func (ws *Wallets) GetMultisigAddresses() []string {
+	var addresses []string
+
+	for address := range ws.Multisigs {
+		addresses = append(addresses, address)
+	}
+
+	return addresses
+}
+
+// GetMultisig returns a multisig wallet by its address, or nil if it is unknown
+func (ws Wallets) GetMultisig(address string) *MultisigWallet {
+	return ws.Multisigs[address]
+}
+
+// GetWatchOnlyAddresses returns an array of addresses watched without their keys
+func (ws *Wallets) GetWatchOnlyAddresses() []string {
+	var addresses []string
+
+	for address := range ws.WatchOnly {
+		addresses = append(addresses, address)
+	}
+
+	return addresses
+}
+
+// GetWatchOnly returns a watch-only wallet by its address, or nil if it is unknown
+func (ws Wallets) GetWatchOnly(address string) *WatchOnlyWallet {
+	return ws.WatchOnly[address]
+}
+
 // GetWallet returns a Wallet by its address
 func (ws Wallets) GetWallet(address string) Wallet {
 	return *ws.Wallets[address]
 }
 
-// LoadFromFile loads wallets from the file
+// GetSigner returns the Wallet signing for the address, watch-only addresses return ErrWatchOnly
+func (ws Wallets) GetSigner(address string) (*Wallet, error) {
+	if wallet, ok := ws.Wallets[address]; ok {
+		return wallet, nil
+	}
+	if _, ok := ws.WatchOnly[address]; ok {
+		return nil, fmt.Errorf("%w, cannot sign for %s", ErrWatchOnly, address)
+	}
+
+	return nil, fmt.Errorf("%s is not in the wallet !!!", address)
+}
+
+// LoadFromFile loads wallets from the file, an encrypted file needs an unlocked session
+// and returns ErrWalletLocked without one
 func (ws *Wallets) LoadFromFile(nodeID string) error {
 	walletFile := fmt.Sprintf(walletFile, nodeID)
 	if _, err := os.Stat(walletFile); os.IsNotExist(err) {
@@ -72,33 +238,80 @@ func (ws *Wallets) LoadFromFile(nodeID string) error {
 		log.Panic(err)
 	}
 
-	var wallets Wallets
-	gob.Register(elliptic.P256())
-	decoder := gob.NewDecoder(bytes.NewReader(fileContent))
-	err = decoder.Decode(&wallets)
+	if !isEncryptedWallet(fileContent) {
+		err = ws.decode(fileContent)
+		if err != nil {
+			log.Panic(err)
+		}
+		return nil
+	}
+
+	session, err := loadSession(nodeID)
+	if err != nil {
+		return err
+	}
+
+	envelope, err := decodeEncryptedWallet(fileContent)
 	if err != nil {
 		log.Panic(err)
 	}
 
+	// the session belongs to a previous passphrase
+	if !bytes.Equal(session.Salt, envelope.Salt) {
+		LockWallets(nodeID)
+		return ErrWalletLocked
+	}
+
+	return ws.decrypt(envelope, session.Key)
+}
+
+// decode fills Wallets from the gob encoded wallets
+func (ws *Wallets) decode(data []byte) error {
+	var wallets Wallets
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	err := decoder.Decode(&wallets)
+	if err != nil {
+		return err
+	}
+
 	ws.Wallets = wallets.Wallets
+	if wallets.Multisigs != nil {
+		ws.Multisigs = wallets.Multisigs
+	}
+	if wallets.WatchOnly != nil {
+		ws.WatchOnly = wallets.WatchOnly
+	}
+	ws.Mnemonic = wallets.Mnemonic
+	ws.NextIndex = wallets.NextIndex
 
 	return nil
 }
 
-// SaveToFile saves wallets to a file
+// SaveToFile saves wallets to a file only its owner can read, encrypted when the wallet has a passphrase
 func (ws Wallets) SaveToFile(nodeID string) {
 	var input bytes.Buffer
 	walletFile := fmt.Sprintf(walletFile, nodeID)
 
-	gob.Register(elliptic.P256())
-
 	encoder := gob.NewEncoder(&input)
 	err := encoder.Encode(ws)
 	if err != nil {
-		return
+		log.Panic(err)
+	}
+
+	// never replace an encrypted file with one in the clear
+	if existing, err := os.ReadFile(walletFile); err == nil && isEncryptedWallet(existing) && !ws.IsEncrypted() {
+		log.Panic(ErrWalletLocked)
+	}
+
+	content := input.Bytes()
+	if ws.IsEncrypted() {
+		content, err = ws.encrypt(content)
+		if err != nil {
+			log.Panic(err)
+		}
 	}
 
-	err = os.WriteFile(walletFile, input.Bytes(), 0644)
+	err = writePrivateFile(walletFile, content)
 	if err != nil {
 		log.Panic(err)
 	}
@@ -0,0 +1,36 @@
+package features
+
+import (
+	"COMP5567-BlockChain/utils"
+	"bytes"
+	"errors"
+)
+
+// ErrWatchOnly is returned when signing with an address whose private key is not in the wallet
+var ErrWatchOnly = errors.New("address is watch-only")
+
+// WatchOnlyWallet is an address tracked without its private key, the public key is optional
+type WatchOnlyWallet struct {
+	Address   string
+	PublicKey []byte
+}
+
+// NewWatchOnlyWallet checks the address and, when given, that the public key belongs to it
+func NewWatchOnlyWallet(address string, publicKey []byte) (*WatchOnlyWallet, error) {
+	if !ValidateAddress(address) {
+		return nil, errors.New("Address is not valid !!!")
+	}
+
+	wallet := WatchOnlyWallet{address, publicKey}
+	if len(publicKey) > 0 && !bytes.Equal(HashPubKey(publicKey), wallet.GetPubKeyHash()) {
+		return nil, errors.New("Public key does not belong to the address !!!")
+	}
+
+	return &wallet, nil
+}
+
+// GetPubKeyHash returns the hash the address locks outputs to
+func (w WatchOnlyWallet) GetPubKeyHash() []byte {
+	pubKeyHash := utils.Base64Decode([]byte(w.Address))
+	return pubKeyHash[1 : len(pubKeyHash)-addressChecksumLen]
+}
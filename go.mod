@@ -5,6 +5,7 @@ go 1.16
 require (
 	github.com/boltdb/bolt v1.3.1
 	golang.org/x/crypto v0.15.0
+	golang.org/x/term v0.14.0
 )
 
 require (
@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"errors"
+	"strings"
+)
+
+// mnemonic words are proquints, every 16 bits are spelled as consonant-vowel-consonant-vowel-consonant
+const proquintConsonants = "bdfghjklmnprstvz"
+const proquintVowels = "aiou"
+
+// number of checksum bytes appended to the entropy before it is spelled out
+const mnemonicChecksumLen = 2
+
+// EntropyToMnemonic spells the entropy followed by its checksum as proquint words,
+// the entropy length must be a multiple of two bytes
+func EntropyToMnemonic(entropy []byte) (string, error) {
+	if len(entropy) == 0 || len(entropy)%2 != 0 {
+		return "", errors.New("Entropy length must be a multiple of 2 bytes !!!")
+	}
+
+	checksum := sha256.Sum256(entropy)
+	data := append(append([]byte{}, entropy...), checksum[:mnemonicChecksumLen]...)
+
+	var words []string
+	for i := 0; i < len(data); i += 2 {
+		words = append(words, encodeProquint(uint16(data[i])<<8|uint16(data[i+1])))
+	}
+
+	return strings.Join(words, " "), nil
+}
+
+// MnemonicToEntropy reverses EntropyToMnemonic and checks the checksum
+func MnemonicToEntropy(mnemonic string) ([]byte, error) {
+	words := strings.Fields(strings.ToLower(mnemonic))
+	if len(words) <= mnemonicChecksumLen/2 {
+		return nil, errors.New("Mnemonic is too short !!!")
+	}
+
+	var data []byte
+	for _, word := range words {
+		value, err := decodeProquint(word)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, byte(value>>8), byte(value))
+	}
+
+	entropy := data[:len(data)-mnemonicChecksumLen]
+	checksum := sha256.Sum256(entropy)
+	if string(checksum[:mnemonicChecksumLen]) != string(data[len(data)-mnemonicChecksumLen:]) {
+		return nil, errors.New("Mnemonic checksum does not match !!!")
+	}
+
+	return entropy, nil
+}
+
+func encodeProquint(value uint16) string {
+	word := []byte{
+		proquintConsonants[value>>12&0xf],
+		proquintVowels[value>>10&0x3],
+		proquintConsonants[value>>6&0xf],
+		proquintVowels[value>>4&0x3],
+		proquintConsonants[value&0xf],
+	}
+
+	return string(word)
+}
+
+func decodeProquint(word string) (uint16, error) {
+	if len(word) != 5 {
+		return 0, errors.New("Mnemonic word " + word + " is not valid !!!")
+	}
+
+	var value uint16
+	for i := 0; i < len(word); i++ {
+		alphabet, bits := proquintConsonants, uint(4)
+		if i%2 == 1 {
+			alphabet, bits = proquintVowels, 2
+		}
+
+		index := strings.IndexByte(alphabet, word[i])
+		if index < 0 {
+			return 0, errors.New("Mnemonic word " + word + " is not valid !!!")
+		}
+		value = value<<bits | uint16(index)
+	}
+
+	return value, nil
+}